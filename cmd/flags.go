@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isFlagSet reports whether the flag with the given name was set on the
+// command line, as opposed to keeping its default value
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// explicitFlag returns the value of a flag if it was set on the command line,
+// and an empty string if it only has its default value
+func explicitFlag(name, value string) string {
+	if !isFlagSet(name) {
+		return ""
+	}
+	return value
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/ci"
 	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"github.com/sakajunquality/buildx-telemetry/internal/output"
 	"github.com/sakajunquality/buildx-telemetry/internal/telemetry"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
@@ -23,20 +32,80 @@ var (
 )
 
 var (
-	otlpEndpoint    = flag.String("otlp-endpoint", "localhost:4317", "OpenTelemetry endpoint")
-	serviceName     = flag.String("service-name", "docker-build-telemetry", "Service name for telemetry")
-	debug           = flag.Bool("debug", false, "Debug mode")
-	inputFile       = flag.String("input", "", "Input file (defaults to stdin)")
-	logLevel        = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
-	exitCodeOnError = flag.Int("exit-code-on-error", 1, "Exit code when an error occurs")
-	traceContext    = flag.String("trace-context", "", "W3C Trace Context header for distributed tracing (default: empty)")
-	versionFlag     = flag.String("version", "", "Version information to add to the trace (default: empty)")
-	showVersion     = flag.Bool("v", false, "Show version information and exit")
+	otlpEndpoint     = flag.String("otlp-endpoint", "", "OpenTelemetry endpoint, host:port or URL (default: localhost:4317 for grpc, localhost:4318 for http/protobuf)")
+	otlpProtocol     = flag.String("otlp-protocol", telemetry.ProtocolGRPC, "OTLP protocol (grpc, http/protobuf)")
+	otlpURLPath      = flag.String("otlp-url-path", telemetry.DefaultURLPath, "URL path traces are sent to with http/protobuf")
+	serviceName      = flag.String("service-name", "docker-build-telemetry", "Service name for telemetry")
+	debug            = flag.Bool("debug", false, "Debug mode")
+	inputFile        = flag.String("input", "", "Input file (defaults to stdin)")
+	logLevel         = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	exitCodeOnError  = flag.Int("exit-code-on-error", 1, "Exit code when an error occurs")
+	traceContext     = flag.String("trace-context", "", "W3C Trace Context header for distributed tracing (default: $TRACEPARENT)")
+	traceState       = flag.String("tracestate", "", "W3C tracestate header of the parent trace context (default: $TRACESTATE)")
+	baggageFlag      = flag.String("baggage", "", "W3C baggage header, whose entries are added to the spans as attributes (default: $BAGGAGE)")
+	versionFlag      = flag.String("version", "", "Version information to add to the trace (default: empty)")
+	showVersion      = flag.Bool("v", false, "Show version information and exit")
+	stream           = flag.Bool("stream", false, "Export steps as they complete while the log is being read")
+	maxLogLines      = flag.Int("max-log-lines", telemetry.DefaultMaxLogLines, "Maximum number of output lines attached to each step (0 disables output)")
+	maxLogBytes      = flag.Int("max-log-bytes", telemetry.DefaultMaxLogBytes, "Maximum number of output bytes attached to each step (0 disables output)")
+	otlpInsecure     = flag.Bool("otlp-insecure", true, "Send traces in plain text rather than over TLS, unless TLS certificates are given (ignored for endpoint URLs)")
+	otlpCACert       = flag.String("otlp-ca-cert", "", "CA certificate file to verify the collector with")
+	otlpClientCert   = flag.String("otlp-client-cert", "", "Client certificate file for mTLS")
+	otlpClientKey    = flag.String("otlp-client-key", "", "Client key file for mTLS")
+	tracesSampler    = flag.String("traces-sampler", telemetry.SamplerParentBasedAlwaysOn, "Sampler, as in OTEL_TRACES_SAMPLER")
+	tracesSamplerArg = flag.String("traces-sampler-arg", "", "Sampling ratio of the ratio based samplers, as in OTEL_TRACES_SAMPLER_ARG")
+	sampleFailed     = flag.Bool("sample-failed-builds", false, "Sample builds that failed or did not complete whatever the sampler decides (not with --stream)")
+	minStepDuration  = flag.Duration("min-step-duration", 0, "Drop the spans of successful steps shorter than this, such as 50ms")
+	collapseCached   = flag.Bool("collapse-cached", false, "Replace the spans of cached steps with one span for all of them")
+	batchQueueSize   = flag.Int("batch-max-queue-size", 0, "Maximum number of spans queued for export (default: $OTEL_BSP_MAX_QUEUE_SIZE or 2048)")
+	batchSize        = flag.Int("batch-max-export-batch-size", 0, "Maximum number of spans sent in one export request (default: $OTEL_BSP_MAX_EXPORT_BATCH_SIZE or 512)")
+	batchTimeout     = flag.Duration("batch-export-timeout", 0, "Timeout of one export request (default: $OTEL_BSP_EXPORT_TIMEOUT or 30s)")
+	batchBlocking    = flag.Bool("batch-blocking", false, "Wait for room in a full span queue instead of dropping spans")
+	outputFile       = flag.String("output-file", "", "File to write the traces to as OTLP/JSON")
+	metricsFlag      = flag.Bool("metrics", false, "Export build metrics over OTLP in addition to the traces")
+	logsFlag         = flag.Bool("logs", false, "Export the output of the steps as OTLP log records in addition to the traces")
+	spoolDir         = flag.String("spool-dir", "", "Directory to spool traces to when they cannot be sent, for the flush command")
+	offline          = flag.Bool("offline", false, "Only write the traces to --output-file without sending them")
+	otlpHeadersFile  = flag.String("otlp-headers-file", "", "File with one key=value header per line to send with the traces")
+	buildRef         = flag.String("build-ref", "", "Build reference, such as the buildx ref, to derive the trace ID from so that re-exports create the same trace")
+	traceIDKey       = flag.String("trace-id-key", "", "Key to derive the trace ID from along with the build reference, or with a hash of the log without --build-ref")
+	traceParentFile  = flag.String("traceparent-file", "", "File to write the traceparent of the build span to, for later steps to continue the trace")
+	exportsFile      = flag.String("exports-file", "", "File to write export TRACEPARENT=... shell lines to, for later steps to continue the trace")
+	githubOutput     = flag.Bool("github-output", false, "Add the trace ID and traceparent to the outputs of the GitHub Actions step")
+	githubEnv        = flag.Bool("github-env", false, "Set TRACEPARENT for the later steps of the GitHub Actions job")
+	traceURLTemplate = flag.String("trace-url-template", "", "Template of a link to the trace, such as https://tempo.example/trace/{{.TraceID}}")
+	traceURLNotice   = flag.Bool("trace-url-annotation", false, "Show the link to the trace as a GitHub Actions notice annotation")
+	githubSummary    = flag.Bool("github-summary", false, "Add the trace ID and link to the GitHub Actions job summary")
+	resourceAttrFile = flag.String("resource-attrs-file", "", "File with one key=value resource attribute per line")
+	spanAttrFile     = flag.String("span-attrs-file", "", "File with one key=value build span attribute per line")
+	resourceDetect   = flag.String("resource-detectors", ci.Auto, "Comma separated CI resource detectors: auto, none, github, cloudbuild, gitlab, circleci, buildkite or jenkins")
 )
 
+// headersEnv is the environment variable holding comma separated key=value
+// headers to send with the traces
+const headersEnv = "BUILDX_TELEMETRY_OTLP_HEADERS"
+
+var (
+	otlpHeaders   stringList
+	resourceAttrs stringList
+	spanAttrs     stringList
+)
+
+func init() {
+	flag.Var(&otlpHeaders, "otlp-header", "Header to send with the traces as key=value (repeatable)")
+	flag.Var(&resourceAttrs, "resource-attr", "Resource attribute as key=value, taking precedence over OTEL_RESOURCE_ATTRIBUTES (repeatable)")
+	flag.Var(&spanAttrs, "span-attr", "Attribute of the build span as key=value (repeatable)")
+}
+
 func main() {
 	flag.Parse()
 
+	// Flags may also follow the flush command
+	flush := flag.Arg(0) == "flush"
+	if flush {
+		flag.CommandLine.Parse(flag.Args()[1:]) //nolint:errcheck
+	}
+
 	// Show version information if requested
 	if *showVersion {
 		fmt.Printf("buildx-telemetry version %s, commit %s, built on %s\n", version, commit, date)
@@ -60,10 +129,28 @@ func main() {
 		zap.String("app_version", version),
 		zap.String("service", *serviceName),
 		zap.String("otlp-endpoint", *otlpEndpoint),
+		zap.String("otlp-protocol", *otlpProtocol),
 		zap.Bool("debug", *debug),
 		zap.Int("exit-code-on-error", *exitCodeOnError),
 		zap.String("trace-context", *traceContext),
-		zap.String("version", *versionFlag))
+		zap.String("version", *versionFlag),
+		zap.Bool("stream", *stream))
+
+	if flush {
+		flushSpool(log)
+		return
+	}
+
+	// Parse the trace URL template before reading the log, so that a broken
+	// template does not fail the run only once the build is exported
+	var urlTemplate *output.TraceURLTemplate
+	if *traceURLTemplate != "" {
+		urlTemplate, err = output.ParseTraceURLTemplate(*traceURLTemplate)
+		if err != nil {
+			log.Error("Invalid trace URL template", zap.Error(err))
+			os.Exit(*exitCodeOnError)
+		}
+	}
 
 	// Set up the input reader
 	var reader *os.File
@@ -82,37 +169,243 @@ func main() {
 		log.Info("Reading from stdin")
 	}
 
-	// Parse buildx logs
-	parser := buildx.NewParserWithLogger(reader, log)
-	steps, err := parser.Parse()
+	// Derive the trace ID from the build if requested, which reads the
+	// whole log when it has to be hashed
+	seed, input, err := idSeed(reader)
 	if err != nil {
-		log.Error("Error parsing log", zap.Error(err))
+		log.Error("Error deriving trace ID", zap.Error(err))
 		os.Exit(*exitCodeOnError)
 	}
 
-	log.Info("Parsed build log", zap.Int("step_count", len(steps)))
+	// Set up the parent trace context, from the flags or else from the
+	// TRACEPARENT, TRACESTATE and BAGGAGE environment variables
+	parent := telemetry.Parent{
+		TraceParent: *traceContext,
+		TraceState:  *traceState,
+		Baggage:     *baggageFlag,
+	}
+	parent.ApplyEnv(os.LookupEnv)
+	ctx, err := parent.Extract(context.Background())
+	if err != nil {
+		log.Warn("Ignoring invalid parent context", zap.Error(err))
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		log.Info("Using parent trace context",
+			zap.String("traceID", spanCtx.TraceID().String()),
+			zap.String("spanID", spanCtx.SpanID().String()),
+			zap.String("traceState", spanCtx.TraceState().String()))
+	}
+
+	// Initialize telemetry tracer
+	tracerConfig, err := tracerConfigFromFlags(log)
+	if err != nil {
+		log.Error("Error configuring tracer", zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
+	tracerConfig.IDSeed = seed
 
-	// Set up trace context if provided
-	ctx := context.Background()
-	if *traceContext != "" {
-		tc := propagation.TraceContext{}
-		headerMap := propagation.MapCarrier{"traceparent": *traceContext}
-		ctx = tc.Extract(ctx, headerMap)
+	if tracerConfig.Logs && tracerConfig.Offline {
+		log.Warn("Log records are not written to the output file, skipping them in offline mode")
+	}
+	if tracerConfig.SampleFailedBuilds && *stream {
+		log.Warn("The outcome of a streamed build is not known when it starts, leaving failed builds to the sampler")
+	}
+	tracer, err := telemetry.NewTracerWithLogger(ctx, tracerConfig, log)
+	if err != nil {
+		log.Error("Error initializing tracer", zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
 
-		spanCtx := trace.SpanContextFromContext(ctx)
-		if spanCtx.IsValid() {
-			log.Info("Using parent trace context",
-				zap.String("traceID", spanCtx.TraceID().String()),
-				zap.String("spanID", spanCtx.SpanID().String()))
+	// Initialize build metrics if requested
+	var metrics *telemetry.Metrics
+	if *metricsFlag {
+		if tracerConfig.Offline {
+			log.Warn("Metrics are not written to the output file, skipping them in offline mode")
 		} else {
-			log.Warn("Invalid trace context provided", zap.String("trace-context", *traceContext))
+			metrics, err = telemetry.NewMetricsWithLogger(ctx, tracerConfig, log)
+			if err != nil {
+				log.Error("Error initializing metrics", zap.Error(err))
+				os.Exit(*exitCodeOnError)
+			}
 		}
 	}
 
-	// Initialize telemetry tracer
+	// Export traces, either while reading the log or after parsing all of it
+	parser := buildx.NewParserWithLogger(input, log)
+
+	var result telemetry.ExportResult
+	var warnings []buildx.Warning
+	if *stream {
+		result, warnings, err = streamBuildTraces(ctx, parser, tracer, metrics, log)
+		if err != nil {
+			log.Error("Error streaming traces", zap.Error(err))
+			if err := tracer.Shutdown(ctx); err != nil {
+				log.Error("Error shutting down tracer", zap.Error(err))
+			}
+			os.Exit(*exitCodeOnError)
+		}
+	} else {
+		graph, err := parser.ParseGraph()
+		if err != nil {
+			log.Error("Error parsing log", zap.Error(err))
+			os.Exit(*exitCodeOnError)
+		}
+
+		log.Info("Parsed build log",
+			zap.Int("step_count", graph.Len()),
+			zap.Int("warning_count", len(graph.Warnings())))
+
+		result, err = tracer.ExportBuildTraces(ctx, graph)
+		if err != nil {
+			log.Error("Error exporting traces", zap.Error(err))
+			os.Exit(*exitCodeOnError)
+		}
+		warnings = graph.Warnings()
+
+		if metrics != nil {
+			metrics.RecordGraph(ctx, graph)
+		}
+
+		// Print debug information if requested
+		if *debug {
+			log.Debug("Printing detailed build steps")
+			buildx.PrintSteps(graph.Steps())
+		}
+	}
+
+	// Flush the spans before reporting the trace, so that a trace that never
+	// reached the collector is reported as an error
+	if err := tracer.Shutdown(ctx); err != nil {
+		log.Error("Error delivering traces",
+			zap.String("traceID", result.TraceID),
+			zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
+	if metrics != nil {
+		if err := metrics.Shutdown(ctx); err != nil {
+			log.Error("Error delivering metrics", zap.Error(err))
+			os.Exit(*exitCodeOnError)
+		}
+	}
+	if spooled := tracer.Spooled(); spooled > 0 {
+		log.Warn("Some traces were spooled, run the flush command to deliver them",
+			zap.Int("batches", spooled),
+			zap.String("spool-dir", *spoolDir))
+	}
+
+	var traceURL string
+	if urlTemplate != nil {
+		traceURL, err = urlTemplate.Render(output.TraceURLData{
+			TraceID: result.TraceID,
+			SpanID:  result.SpanContext.SpanID().String(),
+			Service: tracerConfig.ServiceName,
+			Start:   result.Start,
+			End:     result.End,
+		})
+		if err != nil {
+			log.Error("Error rendering trace URL", zap.Error(err))
+			os.Exit(*exitCodeOnError)
+		}
+	}
+
+	log.Info("Exported traces", zap.String("traceID", result.TraceID), zap.String("traceURL", traceURL))
+	fmt.Printf("TraceID: %s\n", result.TraceID)
+	if traceURL != "" {
+		fmt.Printf("TraceURL: %s\n", traceURL)
+		if *traceURLNotice {
+			fmt.Println(output.GitHubNotice("Build trace", traceURL))
+		}
+	}
+	buildx.PrintWarnings(warnings)
+
+	if err := writeContextOutputs(result, traceURL, log); err != nil {
+		log.Error("Error writing the build span context", zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
+}
+
+// flushSpool delivers the traces spooled to --spool-dir
+func flushSpool(log logger.Logger) {
+	if *spoolDir == "" {
+		log.Error("The flush command requires --spool-dir")
+		os.Exit(*exitCodeOnError)
+	}
+
+	tracerConfig, err := tracerConfigFromFlags(log)
+	if err != nil {
+		log.Error("Error configuring tracer", zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
+
+	delivered, err := telemetry.FlushSpool(context.Background(), tracerConfig, *spoolDir, log)
+	if err != nil {
+		log.Error("Error flushing spooled traces",
+			zap.Int("delivered", delivered),
+			zap.Error(err))
+		os.Exit(*exitCodeOnError)
+	}
+	log.Info("Flushed spooled traces", zap.Int("delivered", delivered))
+}
+
+// tracerConfigFromFlags builds the tracer configuration from the flags and
+// the environment. Invalid flags are an error, while invalid environment
+// variables are logged and ignored.
+func tracerConfigFromFlags(log logger.Logger) (telemetry.Config, error) {
+	headers, err := exporterHeaders()
+	if err != nil {
+		return telemetry.Config{}, err
+	}
+	resourceAttributes, err := attributes(*resourceAttrFile, resourceAttrs)
+	if err != nil {
+		return telemetry.Config{}, fmt.Errorf("resource attributes: %w", err)
+	}
+	spanAttributes, err := attributes(*spanAttrFile, spanAttrs)
+	if err != nil {
+		return telemetry.Config{}, fmt.Errorf("span attributes: %w", err)
+	}
+
+	// Flags given on the command line take precedence over the standard
+	// OpenTelemetry environment variables, which take precedence over the
+	// defaults
 	tracerConfig := telemetry.Config{
-		OTLPEndpoint: *otlpEndpoint,
-		ServiceName:  *serviceName,
+		OTLPEndpoint:            explicitFlag("otlp-endpoint", *otlpEndpoint),
+		Protocol:                explicitFlag("otlp-protocol", *otlpProtocol),
+		URLPath:                 *otlpURLPath,
+		CACertFile:              *otlpCACert,
+		ClientCertFile:          *otlpClientCert,
+		ClientKeyFile:           *otlpClientKey,
+		Headers:                 headers,
+		ResourceAttributes:      resourceAttributes,
+		SpanAttributes:          spanAttributes,
+		ServiceName:             explicitFlag("service-name", *serviceName),
+		Sampler:                 explicitFlag("traces-sampler", *tracesSampler),
+		SamplerArg:              explicitFlag("traces-sampler-arg", *tracesSamplerArg),
+		SampleFailedBuilds:      *sampleFailed,
+		MinStepDuration:         *minStepDuration,
+		CollapseCached:          *collapseCached,
+		BatchMaxQueueSize:       *batchQueueSize,
+		BatchMaxExportBatchSize: *batchSize,
+		BatchExportTimeout:      *batchTimeout,
+		BatchBlocking:           *batchBlocking,
+		OutputFile:              *outputFile,
+		Offline:                 *offline,
+		SpoolDir:                *spoolDir,
+		MaxLogLines:             *maxLogLines,
+		MaxLogBytes:             *maxLogBytes,
+		Logs:                    *logsFlag,
+	}
+	tracerConfig.ApplyEnvWithLogger(os.LookupEnv, log)
+	if tracerConfig.ResourceDetectors, err = ci.Resolve(strings.Split(*resourceDetect, ","), os.LookupEnv); err != nil {
+		return telemetry.Config{}, err
+	}
+	if tracerConfig.ServiceName == "" {
+		tracerConfig.ServiceName = *serviceName
+	}
+
+	// TLS certificates imply a secure connection unless told otherwise
+	tracerConfig.TLS = !*otlpInsecure
+	if isFlagSet("otlp-insecure") && *otlpInsecure && (tracerConfig.CACertFile != "" || tracerConfig.ClientCertFile != "" || tracerConfig.ClientKeyFile != "") {
+		return telemetry.Config{}, errors.New("TLS certificates are configured but --otlp-insecure is set")
 	}
 
 	// Add version if provided
@@ -123,30 +416,232 @@ func main() {
 		tracerConfig.Version = version
 	}
 
-	tracer, err := telemetry.NewTracerWithLogger(ctx, tracerConfig, log)
-	if err != nil {
-		log.Error("Error initializing tracer", zap.Error(err))
-		os.Exit(*exitCodeOnError)
+	return tracerConfig, nil
+}
+
+// exporterHeaders collects the headers to send with the traces. Headers
+// given with --otlp-header override those from --otlp-headers-file, which
+// override those from the environment. Reading them from a file or the
+// environment keeps secrets out of the process list.
+func exporterHeaders() (map[string]string, error) {
+	headers := make(map[string]string)
+
+	if value := os.Getenv(headersEnv); value != "" {
+		parsed, err := telemetry.ParseHeaders(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", headersEnv, err)
+		}
+		for key, value := range parsed {
+			headers[key] = value
+		}
+	}
+
+	if *otlpHeadersFile != "" {
+		parsed, err := telemetry.ReadHeadersFile(*otlpHeadersFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range parsed {
+			headers[key] = value
+		}
 	}
-	defer func() {
-		if err := tracer.Shutdown(ctx); err != nil {
-			log.Error("Error shutting down tracer", zap.Error(err))
+
+	for _, header := range otlpHeaders {
+		key, value, err := telemetry.ParseHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("parsing --otlp-header: %w", err)
 		}
-	}()
+		headers[key] = value
+	}
+
+	return headers, nil
+}
+
+// streamBuildTraces exports the steps as they complete while the log is being
+// read. On SIGINT or SIGTERM the steps still running are exported as
+// incomplete and the stream returns without waiting for the input, whose read
+// may never return. A second signal stops the application as usual. Metrics
+// are recorded along the way if metrics is not nil.
+func streamBuildTraces(ctx context.Context, parser *buildx.Parser, tracer *telemetry.Tracer, metrics *telemetry.Metrics, log logger.Logger) (telemetry.ExportResult, []buildx.Warning, error) {
+	log.Info("Streaming build traces")
+
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Export traces
-	traceID, err := tracer.ExportBuildTraces(ctx, steps)
+	var warnings []buildx.Warning
+	var stats buildx.Stats
+	stream := tracer.NewBuildStream(ctx)
+	streamErr := parser.StreamContext(signalCtx, func(event buildx.Event) error {
+		switch event.Type {
+		case buildx.EventStepCompleted:
+			stats.Add(event.Step)
+			if metrics != nil {
+				metrics.RecordStep(ctx, event.Step)
+			}
+		case buildx.EventWarning:
+			warnings = append(warnings, event.Warning)
+			if metrics != nil {
+				metrics.RecordWarning(ctx, event.Warning)
+			}
+		}
+		return stream.Handle(event)
+	})
+	if signalCtx.Err() != nil {
+		// Let another signal stop the application while the spans of the
+		// interrupted build are delivered
+		stop()
+		log.Warn("Interrupted, exporting the running steps as incomplete")
+	}
+	if metrics != nil {
+		metrics.RecordBuild(ctx, stats)
+	}
+
+	// Close the stream even if reading failed, so the spans exported so
+	// far end up in a complete trace
+	result, err := stream.Close()
 	if err != nil {
-		log.Error("Error exporting traces", zap.Error(err))
-		os.Exit(*exitCodeOnError)
+		return telemetry.ExportResult{}, nil, err
+	}
+	if streamErr != nil && signalCtx.Err() == nil {
+		return result, warnings, fmt.Errorf("reading log: %w", streamErr)
+	}
+	return result, warnings, nil
+}
+
+// writeContextOutputs hands the context of the docker-build span and the link
+// to the trace to the later steps of the pipeline, as requested by the flags,
+// so that they can continue the trace
+func writeContextOutputs(result telemetry.ExportResult, traceURL string, log logger.Logger) error {
+	traceParent := result.TraceParent()
+	if traceParent == "" {
+		log.Warn("No build span context to write")
+		return nil
+	}
+
+	env := []output.Var{{Name: "TRACEPARENT", Value: traceParent}}
+	if state := result.TraceState(); state != "" {
+		env = append(env, output.Var{Name: "TRACESTATE", Value: state})
+	}
+
+	if *traceParentFile != "" {
+		if err := output.WriteTraceParent(*traceParentFile, traceParent); err != nil {
+			return err
+		}
+		log.Info("Wrote traceparent", zap.String("file", *traceParentFile))
+	}
+
+	if *exportsFile != "" {
+		if err := output.WriteShellExports(*exportsFile, env); err != nil {
+			return err
+		}
+		log.Info("Wrote trace context exports", zap.String("file", *exportsFile))
+	}
+
+	if *githubOutput {
+		outputs := []output.Var{
+			{Name: "trace-id", Value: result.TraceID},
+			{Name: "traceparent", Value: traceParent},
+		}
+		if state := result.TraceState(); state != "" {
+			outputs = append(outputs, output.Var{Name: "tracestate", Value: state})
+		}
+		if traceURL != "" {
+			outputs = append(outputs, output.Var{Name: "trace-url", Value: traceURL})
+		}
+		if err := appendGitHubFile("GITHUB_OUTPUT", outputs, log); err != nil {
+			return err
+		}
+	}
+
+	if *githubSummary {
+		if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+			if err := output.AppendStepSummary(path, traceSummary(result.TraceID, traceURL)); err != nil {
+				return err
+			}
+		} else {
+			log.Warn("Not running in GitHub Actions, skipping the job summary")
+		}
+	}
+
+	if *githubEnv {
+		if err := appendGitHubFile("GITHUB_ENV", env, log); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// traceSummary returns the markdown of the job summary, linking to the trace
+// if there is a link
+func traceSummary(traceID, traceURL string) string {
+	if traceURL == "" {
+		return fmt.Sprintf("Build trace: `%s`", traceID)
 	}
+	return fmt.Sprintf("Build trace: [`%s`](%s)", traceID, traceURL)
+}
 
-	log.Info("Exported traces", zap.String("traceID", traceID))
-	fmt.Printf("TraceID: %s\n", traceID)
+// appendGitHubFile appends variables to the GitHub Actions file named by the
+// environment variable envName, warning when not running in GitHub Actions
+func appendGitHubFile(envName string, vars []output.Var, log logger.Logger) error {
+	path := os.Getenv(envName)
+	if path == "" {
+		log.Warn("Not running in GitHub Actions, skipping the output", zap.String("env", envName))
+		return nil
+	}
+	if err := output.AppendGitHubFile(path, vars); err != nil {
+		return err
+	}
+	log.Info("Wrote the build span context for GitHub Actions", zap.String("env", envName))
+	return nil
+}
 
-	// Print debug information if requested
-	if *debug {
-		log.Debug("Printing detailed build steps")
-		buildx.PrintSteps(steps)
+// attributes collects key=value attributes from a file, if there is one, and
+// from flags, which override those from the file
+func attributes(path string, flags []string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if path != "" {
+		parsed, err := telemetry.ReadAttributesFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range parsed {
+			attrs[key] = value
+		}
+	}
+
+	for _, attr := range flags {
+		key, value, err := telemetry.ParseAttribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		attrs[key] = value
+	}
+
+	return attrs, nil
+}
+
+// idSeed returns the seed trace IDs are derived from, if any, and the reader
+// to parse the log from. With --build-ref the seed is the build reference,
+// prefixed with "<key>:" when --trace-id-key is given. With --trace-id-key
+// alone, it is the key and the SHA-256 hash of the log, so the log is read
+// entirely first.
+func idSeed(reader io.Reader) (string, io.Reader, error) {
+	switch {
+	case *buildRef != "" && *traceIDKey != "":
+		return *traceIDKey + ":" + *buildRef, reader, nil
+	case *buildRef != "":
+		return *buildRef, reader, nil
+	case *traceIDKey == "":
+		return "", reader, nil
+	case *stream:
+		return "", nil, errors.New("--trace-id-key needs --build-ref with --stream, since the log is hashed before it is exported")
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading log: %w", err)
 	}
+	sum := sha256.Sum256(data)
+	return *traceIDKey + ":" + hex.EncodeToString(sum[:]), bytes.NewReader(data), nil
 }
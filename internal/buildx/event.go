@@ -0,0 +1,38 @@
+package buildx
+
+// EventType identifies the kind of a build event
+type EventType int
+
+// Build event types
+const (
+	// EventStepStarted is sent when a vertex execution starts. The step
+	// only carries its name, inputs and start time at that point.
+	EventStepStarted EventType = iota
+	// EventStepCompleted is sent when a vertex execution completes, with
+	// its statuses and output. Executions still running at the end of the
+	// log are sent as incomplete.
+	EventStepCompleted
+	// EventWarning is sent for every build warning
+	EventWarning
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventStepStarted:
+		return "step-started"
+	case EventStepCompleted:
+		return "step-completed"
+	case EventWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change in the state of the build, as seen in the log stream
+type Event struct {
+	Type    EventType
+	Step    BuildStep
+	Warning Warning
+}
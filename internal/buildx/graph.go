@@ -0,0 +1,304 @@
+package buildx
+
+import (
+	"sort"
+	"time"
+)
+
+// Graph is the dependency graph of the build steps, keyed by vertex digest
+type Graph struct {
+	steps      []BuildStep
+	byDigest   map[string][]int
+	dependents map[string][]string
+	warnings   []Warning
+}
+
+// NewGraph builds the dependency graph from the given build steps.
+// Steps are ordered so that every step comes after the steps it depends on,
+// with ties broken by start time.
+func NewGraph(steps []BuildStep) *Graph {
+	g := &Graph{
+		byDigest:   make(map[string][]int),
+		dependents: make(map[string][]string),
+	}
+
+	// Group executions by digest and remember the first one seen for each
+	var digests []string
+	executions := make(map[string][]BuildStep)
+	for _, step := range steps {
+		if _, ok := executions[step.Digest]; !ok {
+			digests = append(digests, step.Digest)
+		}
+		executions[step.Digest] = append(executions[step.Digest], step)
+	}
+
+	for _, digest := range digests {
+		sort.SliceStable(executions[digest], func(i, j int) bool {
+			return executions[digest][i].Started.Before(executions[digest][j].Started)
+		})
+	}
+
+	// Count the pending inputs of every vertex, ignoring inputs that never
+	// showed up as a step of their own
+	pending := make(map[string]int, len(digests))
+	for _, digest := range digests {
+		for _, input := range inputsOf(executions[digest]) {
+			if _, ok := executions[input]; !ok || input == digest {
+				continue
+			}
+			pending[digest]++
+			g.dependents[input] = append(g.dependents[input], digest)
+		}
+	}
+
+	var ready []string
+	for _, digest := range digests {
+		if pending[digest] == 0 {
+			ready = append(ready, digest)
+		}
+	}
+
+	earliest := func(digest string) int64 {
+		return executions[digest][0].Started.UnixNano()
+	}
+
+	visited := make(map[string]bool, len(digests))
+	for len(digests) > len(visited) {
+		if len(ready) == 0 {
+			// A cycle should not happen in a BuildKit graph, but make sure
+			// every step is still emitted if it does
+			for _, digest := range digests {
+				if !visited[digest] {
+					ready = append(ready, digest)
+					break
+				}
+			}
+		}
+
+		sort.SliceStable(ready, func(i, j int) bool {
+			return earliest(ready[i]) < earliest(ready[j])
+		})
+		digest := ready[0]
+		ready = ready[1:]
+		if visited[digest] {
+			continue
+		}
+		visited[digest] = true
+
+		for _, step := range executions[digest] {
+			g.byDigest[digest] = append(g.byDigest[digest], len(g.steps))
+			g.steps = append(g.steps, step)
+		}
+
+		for _, dependent := range g.dependents[digest] {
+			pending[dependent]--
+			if pending[dependent] == 0 && !visited[dependent] {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	return g
+}
+
+// inputsOf returns the distinct inputs of all executions of a vertex
+func inputsOf(executions []BuildStep) []string {
+	seen := make(map[string]bool)
+	var inputs []string
+	for _, step := range executions {
+		for _, input := range step.Inputs {
+			if !seen[input] {
+				seen[input] = true
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	return inputs
+}
+
+// Len returns the number of steps in the graph
+func (g *Graph) Len() int {
+	return len(g.steps)
+}
+
+// Steps returns the build steps in dependency order
+func (g *Graph) Steps() []BuildStep {
+	return g.steps
+}
+
+// Step returns the latest execution of the vertex with the given digest
+func (g *Graph) Step(digest string) (BuildStep, bool) {
+	indexes := g.byDigest[digest]
+	if len(indexes) == 0 {
+		return BuildStep{}, false
+	}
+	return g.steps[indexes[len(indexes)-1]], true
+}
+
+// Inputs returns the steps the vertex with the given digest depends on.
+// Inputs that are not part of the graph are skipped.
+func (g *Graph) Inputs(digest string) []BuildStep {
+	indexes := g.byDigest[digest]
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	var inputs []BuildStep
+	for _, input := range g.steps[indexes[len(indexes)-1]].Inputs {
+		if step, ok := g.Step(input); ok {
+			inputs = append(inputs, step)
+		}
+	}
+	return inputs
+}
+
+// Dependents returns the digests of the vertices that depend on the given one
+func (g *Graph) Dependents(digest string) []string {
+	return g.dependents[digest]
+}
+
+// Warnings returns the warnings emitted during the build
+func (g *Graph) Warnings() []Warning {
+	return g.warnings
+}
+
+// Build outcomes
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFailed     = "failed"
+	OutcomeIncomplete = "incomplete"
+)
+
+// Stats holds aggregate figures about the steps of a build. Steps are added
+// one by one, so the figures can also be kept up to date while streaming.
+// CacheableSteps and CachedSteps only count Dockerfile steps, as internal
+// work such as loading the build definition is never cached.
+type Stats struct {
+	Steps           int
+	CacheableSteps  int
+	CachedSteps     int
+	FailedSteps     int
+	IncompleteSteps int
+	ExecutionTime   time.Duration
+	Start           time.Time
+	End             time.Time
+}
+
+// Add accounts for a completed step
+func (s *Stats) Add(step BuildStep) {
+	s.Steps++
+	if !ParseVertexName(step.Name).Internal() {
+		s.CacheableSteps++
+		if step.Cached {
+			s.CachedSteps++
+		}
+	}
+	if step.Error != "" {
+		s.FailedSteps++
+	}
+	if step.Incomplete {
+		s.IncompleteSteps++
+	}
+	s.ExecutionTime += step.Completed.Sub(step.Started)
+
+	if s.Start.IsZero() || step.Started.Before(s.Start) {
+		s.Start = step.Started
+	}
+	if step.Completed.After(s.End) {
+		s.End = step.Completed
+	}
+}
+
+// CacheHitRatio returns the share of Dockerfile steps that were cached
+func (s Stats) CacheHitRatio() float64 {
+	if s.CacheableSteps == 0 {
+		return 0
+	}
+	return float64(s.CachedSteps) / float64(s.CacheableSteps)
+}
+
+// Outcome returns the overall outcome of the build: failed if any step
+// failed, incomplete if any step never completed, and success otherwise
+func (s Stats) Outcome() string {
+	switch {
+	case s.FailedSteps > 0:
+		return OutcomeFailed
+	case s.IncompleteSteps > 0:
+		return OutcomeIncomplete
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// Stats returns aggregate figures about the steps of the build.
+// The execution time is the sum of the durations of all steps.
+func (g *Graph) Stats() Stats {
+	var stats Stats
+	for _, step := range g.steps {
+		stats.Add(step)
+	}
+	return stats
+}
+
+// Outcome returns the overall outcome of the build
+func (g *Graph) Outcome() string {
+	return g.Stats().Outcome()
+}
+
+// Start returns the earliest start time of the steps in the build
+func (g *Graph) Start() time.Time {
+	return g.Stats().Start
+}
+
+// End returns the latest completion time of the steps in the build
+func (g *Graph) End() time.Time {
+	return g.Stats().End
+}
+
+// Stage is a group of steps of the same Dockerfile stage, or the internal
+// work of the build
+type Stage struct {
+	Name      string
+	Started   time.Time
+	Completed time.Time
+	Steps     int
+	Failed    bool
+}
+
+// Stages returns the stages of the build in the order they started
+func (g *Graph) Stages() []Stage {
+	var stages []*Stage
+	byName := make(map[string]*Stage)
+	for _, step := range g.steps {
+		name := ParseVertexName(step.Name).Group()
+		stage, ok := byName[name]
+		if !ok {
+			stage = &Stage{
+				Name:      name,
+				Started:   step.Started,
+				Completed: step.Completed,
+			}
+			byName[name] = stage
+			stages = append(stages, stage)
+		}
+
+		stage.Steps++
+		stage.Failed = stage.Failed || step.Error != ""
+		if step.Started.Before(stage.Started) {
+			stage.Started = step.Started
+		}
+		if step.Completed.After(stage.Completed) {
+			stage.Completed = step.Completed
+		}
+	}
+
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].Started.Before(stages[j].Started)
+	})
+
+	result := make([]Stage, 0, len(stages))
+	for _, stage := range stages {
+		result = append(result, *stage)
+	}
+	return result
+}
@@ -0,0 +1,183 @@
+package buildx
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGraph_DependencyOrder(t *testing.T) {
+	base, _ := time.Parse(time.RFC3339, "2023-01-01T00:00:00Z")
+
+	// The dependent step is listed first and even claims an earlier start
+	steps := []BuildStep{
+		{Digest: "sha256:c", Name: "step-c", Inputs: []string{"sha256:b"}, Started: base},
+		{Digest: "sha256:b", Name: "step-b", Inputs: []string{"sha256:a"}, Started: base.Add(2 * time.Second)},
+		{Digest: "sha256:a", Name: "step-a", Started: base.Add(time.Second)},
+		{Digest: "sha256:d", Name: "step-d", Started: base.Add(3 * time.Second)},
+	}
+
+	graph := NewGraph(steps)
+
+	if graph.Len() != 4 {
+		t.Fatalf("Expected 4 steps, got %d", graph.Len())
+	}
+
+	position := make(map[string]int)
+	for i, step := range graph.Steps() {
+		position[step.Digest] = i
+	}
+
+	if position["sha256:a"] > position["sha256:b"] || position["sha256:b"] > position["sha256:c"] {
+		t.Errorf("Expected steps in dependency order, got %v", position)
+	}
+
+	inputs := graph.Inputs("sha256:b")
+	if len(inputs) != 1 || inputs[0].Name != "step-a" {
+		t.Errorf("Expected step-b to depend on step-a, got %v", inputs)
+	}
+
+	dependents := graph.Dependents("sha256:a")
+	if len(dependents) != 1 || dependents[0] != "sha256:b" {
+		t.Errorf("Expected step-a to be depended on by step-b, got %v", dependents)
+	}
+
+	if _, ok := graph.Step("sha256:unknown"); ok {
+		t.Errorf("Expected unknown digest to be missing from the graph")
+	}
+}
+
+func TestGraph_UnknownInputs(t *testing.T) {
+	steps := []BuildStep{
+		{Digest: "sha256:a", Name: "step-a", Inputs: []string{"sha256:missing"}},
+	}
+
+	graph := NewGraph(steps)
+
+	if graph.Len() != 1 {
+		t.Fatalf("Expected 1 step, got %d", graph.Len())
+	}
+
+	if inputs := graph.Inputs("sha256:a"); len(inputs) != 0 {
+		t.Errorf("Expected no known inputs, got %d", len(inputs))
+	}
+}
+
+func TestParser_ParseGraphFromLog(t *testing.T) {
+	file, err := os.Open("../../data/log.1")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	graph, err := NewParser(file).ParseGraph()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	const (
+		from = "sha256:0e5317e2fca486a2b330dc5431c7e962917aef2bdcbc3f89c8eac64e5354a0bf"
+		run  = "sha256:0cb2ca40fd8adf1fd0b038eb400d4575c2118269543bff7ec3a7560584cc5a42"
+	)
+
+	inputs := graph.Inputs(run)
+	if len(inputs) != 1 || inputs[0].Digest != from {
+		t.Errorf("Expected RUN step to depend on FROM step, got %v", inputs)
+	}
+}
+
+func TestGraph_Stats(t *testing.T) {
+	base, _ := time.Parse(time.RFC3339, "2023-01-01T00:00:00Z")
+
+	steps := []BuildStep{
+		{Digest: "sha256:a", Name: "[stage-0 2/3] COPY . .", Started: base.Add(time.Second), Completed: base.Add(3 * time.Second)},
+		{Digest: "sha256:b", Name: "[stage-0 1/3] FROM alpine", Started: base, Completed: base.Add(time.Second), Cached: true},
+		{Digest: "sha256:c", Name: "[stage-0 3/3] RUN make", Started: base.Add(2 * time.Second), Completed: base.Add(5 * time.Second)},
+	}
+
+	graph := NewGraph(steps)
+
+	if !graph.Start().Equal(base) {
+		t.Errorf("Expected build to start at %v, got %v", base, graph.Start())
+	}
+	if !graph.End().Equal(base.Add(5 * time.Second)) {
+		t.Errorf("Expected build to end at %v, got %v", base.Add(5*time.Second), graph.End())
+	}
+
+	stats := graph.Stats()
+	if stats.Steps != 3 || stats.CachedSteps != 1 {
+		t.Errorf("Expected 3 steps with 1 cached, got %d with %d cached", stats.Steps, stats.CachedSteps)
+	}
+	if stats.ExecutionTime != 6*time.Second {
+		t.Errorf("Expected 6s of execution time, got %v", stats.ExecutionTime)
+	}
+	if ratio := stats.CacheHitRatio(); ratio < 0.33 || ratio > 0.34 {
+		t.Errorf("Expected cache hit ratio of 1/3, got %f", ratio)
+	}
+
+	if (Stats{}).CacheHitRatio() != 0 {
+		t.Errorf("Expected cache hit ratio of an empty build to be 0")
+	}
+}
+
+func TestStats_CacheHitRatioOfCachedBuild(t *testing.T) {
+	base, _ := time.Parse(time.RFC3339, "2023-01-01T00:00:00Z")
+
+	var stats Stats
+	for _, step := range []BuildStep{
+		{Name: "[internal] load build definition from Dockerfile", Started: base, Completed: base.Add(time.Second)},
+		{Name: "[stage-0 1/2] FROM alpine", Started: base, Completed: base, Cached: true},
+		{Name: "[stage-0 2/2] RUN make", Started: base, Completed: base, Cached: true},
+		{Name: "exporting to image", Started: base, Completed: base.Add(time.Second)},
+	} {
+		stats.Add(step)
+	}
+
+	if stats.Steps != 4 || stats.CacheableSteps != 2 || stats.CachedSteps != 2 {
+		t.Errorf("Expected 4 steps with 2 cacheable and 2 cached, got %+v", stats)
+	}
+	if ratio := stats.CacheHitRatio(); ratio != 1 {
+		t.Errorf("Expected a fully cached build to have a cache hit ratio of 1, got %f", ratio)
+	}
+}
+
+func TestGraph_Stages(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	graph, err := NewParser(file).ParseGraph()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	stages := graph.Stages()
+
+	var names []string
+	steps := 0
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+		steps += stage.Steps
+
+		if stage.Completed.Before(stage.Started) {
+			t.Errorf("Stage %s completed before it started", stage.Name)
+		}
+	}
+
+	expected := []string{InternalStage, "stage-0", "wrench", "stage-3", "stage-1"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected stages %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected stages %v, got %v", expected, names)
+			break
+		}
+	}
+
+	if steps != graph.Len() {
+		t.Errorf("Expected stages to cover all %d steps, got %d", graph.Len(), steps)
+	}
+}
@@ -0,0 +1,429 @@
+package buildx
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// vertexMerger merges the vertex updates BuildKit sends for the same digest
+// into one build step per vertex execution. An execution is identified by
+// the vertex digest and its start time. Status updates are merged the same
+// way and attached to the execution they ran in, as is the output of the
+// execution.
+//
+// A streaming merger queues an event whenever an execution starts or
+// completes, and forgets completed executions so that memory stays bounded
+// by the number of running ones. An execution completed by a log line is
+// only finished at the end of the line, as the line may also carry its final
+// statuses and output. The keys of the finished executions and their
+// statuses are remembered for a while, so that late updates of them are
+// ignored rather than attached to a later execution of the same vertex.
+//
+// mu is held while a line is merged, so that a stream can be stopped between
+// two lines from another goroutine. A stopped merger merges no more lines.
+type vertexMerger struct {
+	mu      sync.Mutex
+	stopped bool
+
+	streaming  bool
+	executions []*execution
+	byStart    map[string]*execution
+	open       map[string]*execution
+	latest     map[string]*execution
+	statuses   map[string]statusRef
+	completed  []*execution
+	done       *recentSet
+	events     []Event
+	lastSeen   time.Time
+}
+
+// maxDoneKeys is the number of keys of finished executions and statuses a
+// streaming merger remembers
+const maxDoneKeys = 4096
+
+// execution is a single run of a vertex being merged
+type execution struct {
+	step       BuildStep
+	key        string
+	statusKeys []string
+}
+
+// statusRef locates a merged status within the statuses of its execution
+type statusRef struct {
+	execution *execution
+	index     int
+}
+
+func newVertexMerger() *vertexMerger {
+	return &vertexMerger{
+		byStart:  make(map[string]*execution),
+		open:     make(map[string]*execution),
+		latest:   make(map[string]*execution),
+		statuses: make(map[string]statusRef),
+		done:     newRecentSet(maxDoneKeys),
+	}
+}
+
+func newStreamingVertexMerger() *vertexMerger {
+	m := newVertexMerger()
+	m.streaming = true
+	return m
+}
+
+// add merges a vertex update into the execution it belongs to
+func (m *vertexMerger) add(vertex Vertex) error {
+	if vertex.Started == "" {
+		if vertex.Completed == "" {
+			if vertex.Error != "" {
+				m.fail(vertex)
+			}
+			// Announcement of a vertex that has not started yet
+			return nil
+		}
+
+		// Completion without a start belongs to the running execution
+		exec, ok := m.open[vertex.Digest]
+		if !ok {
+			return fmt.Errorf("completion of vertex %s that never started", vertex.Digest)
+		}
+		return m.complete(exec, vertex)
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, vertex.Started)
+	if err != nil {
+		return fmt.Errorf("parsing start time: %w", err)
+	}
+	m.observe(started)
+
+	key := vertex.Digest + "@" + started.Format(time.RFC3339Nano)
+	if m.done.has(key) {
+		// Repeated update of an execution that was already emitted
+		return nil
+	}
+
+	exec, ok := m.byStart[key]
+	if !ok {
+		exec = &execution{
+			step: BuildStep{
+				Digest:  vertex.Digest,
+				Started: started,
+			},
+			key: key,
+		}
+		m.byStart[key] = exec
+		m.latest[vertex.Digest] = exec
+		if !m.streaming {
+			m.executions = append(m.executions, exec)
+		}
+	}
+
+	step := &exec.step
+	if vertex.Name != "" {
+		step.Name = vertex.Name
+	}
+	if len(vertex.Inputs) > 0 {
+		step.Inputs = vertex.Inputs
+	}
+	step.Cached = step.Cached || vertex.Cached
+	if vertex.Error != "" {
+		step.Error = vertex.Error
+	}
+
+	if !ok && m.streaming {
+		m.events = append(m.events, Event{Type: EventStepStarted, Step: *step})
+	}
+
+	if vertex.Completed == "" {
+		m.open[vertex.Digest] = exec
+		return nil
+	}
+	return m.complete(exec, vertex)
+}
+
+// fail records the error of a vertex update without a start or completion
+// time. The error belongs to the running execution of the vertex if there is
+// one. Otherwise the vertex failed before it started, as when its inputs
+// could not be resolved, and is recorded as a failed step at the last time
+// seen in the log.
+func (m *vertexMerger) fail(vertex Vertex) {
+	if exec, ok := m.open[vertex.Digest]; ok {
+		exec.step.Error = vertex.Error
+		return
+	}
+
+	key := vertex.Digest + "@"
+	if m.done.has(key) {
+		return
+	}
+	if exec, ok := m.byStart[key]; ok {
+		exec.step.Error = vertex.Error
+		return
+	}
+
+	exec := &execution{
+		step: BuildStep{
+			Digest:    vertex.Digest,
+			Name:      vertex.Name,
+			Inputs:    vertex.Inputs,
+			Cached:    vertex.Cached,
+			Error:     vertex.Error,
+			Started:   m.lastSeen,
+			Completed: m.lastSeen,
+		},
+		key: key,
+	}
+	if !m.streaming {
+		m.byStart[key] = exec
+		m.executions = append(m.executions, exec)
+		return
+	}
+	m.events = append(m.events,
+		Event{Type: EventStepStarted, Step: exec.step},
+		Event{Type: EventStepCompleted, Step: exec.step})
+	m.forget(exec)
+}
+
+// complete records the completion time of an execution
+func (m *vertexMerger) complete(exec *execution, vertex Vertex) error {
+	completed, err := time.Parse(time.RFC3339Nano, vertex.Completed)
+	if err != nil {
+		return fmt.Errorf("parsing completion time: %w", err)
+	}
+
+	m.observe(completed)
+
+	step := &exec.step
+	step.Completed = completed
+	step.Cached = step.Cached || vertex.Cached
+	if vertex.Error != "" {
+		step.Error = vertex.Error
+	}
+	if m.open[vertex.Digest] == exec {
+		delete(m.open, vertex.Digest)
+	}
+
+	if m.streaming {
+		m.completed = append(m.completed, exec)
+	}
+	return nil
+}
+
+// endLine finishes the executions completed by the last log line, once its
+// statuses and output have been merged, and queues their completion
+func (m *vertexMerger) endLine() {
+	for _, exec := range m.completed {
+		// An execution completed twice in the same line is finished once
+		if m.byStart[exec.key] != exec {
+			continue
+		}
+		m.events = append(m.events, Event{Type: EventStepCompleted, Step: exec.step})
+		m.forget(exec)
+	}
+	m.completed = nil
+}
+
+// forget drops a completed execution from the merger state
+func (m *vertexMerger) forget(exec *execution) {
+	m.done.add(exec.key)
+	delete(m.byStart, exec.key)
+	if m.latest[exec.step.Digest] == exec {
+		delete(m.latest, exec.step.Digest)
+	}
+	if m.open[exec.step.Digest] == exec {
+		delete(m.open, exec.step.Digest)
+	}
+	for _, key := range exec.statusKeys {
+		m.done.add(key)
+		delete(m.statuses, key)
+	}
+}
+
+// running returns the execution a status or output of a vertex belongs to
+func (m *vertexMerger) running(digest string) (*execution, bool) {
+	exec, ok := m.open[digest]
+	if !ok {
+		exec, ok = m.latest[digest]
+	}
+	return exec, ok
+}
+
+// addStatus merges a status update into the status it belongs to
+func (m *vertexMerger) addStatus(update VertexStatus) error {
+	key := update.Vertex + "@" + update.ID + "@" + update.Started
+	if m.done.has(key) {
+		// Late update of a status of a finished execution
+		return nil
+	}
+	ref, ok := m.statuses[key]
+	if !ok {
+		exec, ok := m.running(update.Vertex)
+		if !ok {
+			return fmt.Errorf("status for vertex %s that is not running", update.Vertex)
+		}
+
+		exec.step.Statuses = append(exec.step.Statuses, Status{ID: update.ID})
+		exec.statusKeys = append(exec.statusKeys, key)
+		ref = statusRef{execution: exec, index: len(exec.step.Statuses) - 1}
+		m.statuses[key] = ref
+	}
+
+	status := &ref.execution.step.Statuses[ref.index]
+	if update.Name != "" {
+		status.Name = update.Name
+	}
+	status.Current = update.Current
+	if update.Total > 0 {
+		status.Total = update.Total
+	}
+
+	if update.Started != "" && status.Started.IsZero() {
+		started, err := time.Parse(time.RFC3339Nano, update.Started)
+		if err != nil {
+			return fmt.Errorf("parsing status start time: %w", err)
+		}
+		m.observe(started)
+		status.Started = started
+	}
+
+	// Until the status completes, its last update is the best known end
+	end := update.Completed
+	if end == "" {
+		end = update.Timestamp
+	}
+	if end != "" {
+		completed, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			return fmt.Errorf("parsing status completion time: %w", err)
+		}
+		m.observe(completed)
+		status.Completed = completed
+	}
+	return nil
+}
+
+// addLog attaches a chunk of output to the execution that wrote it
+func (m *vertexMerger) addLog(log VertexLog) error {
+	exec, ok := m.running(log.Vertex)
+	if !ok {
+		return fmt.Errorf("output of vertex %s that is not running", log.Vertex)
+	}
+
+	chunk := LogChunk{
+		Stream: log.Stream,
+		Data:   log.Data,
+	}
+	if log.Timestamp != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, log.Timestamp)
+		if err != nil {
+			return fmt.Errorf("parsing log timestamp: %w", err)
+		}
+		m.observe(timestamp)
+		chunk.Timestamp = timestamp
+	}
+
+	exec.step.Logs = append(exec.step.Logs, chunk)
+	return nil
+}
+
+// addWarning queues a build warning
+func (m *vertexMerger) addWarning(warning Warning) {
+	if m.streaming {
+		m.events = append(m.events, Event{Type: EventWarning, Warning: warning})
+	}
+}
+
+// observe records a timestamp seen in the log
+func (m *vertexMerger) observe(t time.Time) {
+	if t.After(m.lastSeen) {
+		m.lastSeen = t
+	}
+}
+
+// closeIncomplete closes an execution that never completed, for example
+// because the build was interrupted, at the last timestamp seen in the log
+func (m *vertexMerger) closeIncomplete(exec *execution) {
+	if !exec.step.Completed.IsZero() {
+		return
+	}
+	exec.step.Incomplete = true
+	exec.step.Completed = exec.step.Started
+	if m.lastSeen.After(exec.step.Started) {
+		exec.step.Completed = m.lastSeen
+	}
+}
+
+// steps returns the executions in the order they were first seen.
+// Executions that never completed are closed and flagged as incomplete.
+func (m *vertexMerger) steps() []BuildStep {
+	steps := make([]BuildStep, 0, len(m.executions))
+	for _, exec := range m.executions {
+		m.closeIncomplete(exec)
+		steps = append(steps, exec.step)
+	}
+	return steps
+}
+
+// flush closes the executions still running at the end of a stream, flags
+// them as incomplete and queues their completion
+func (m *vertexMerger) flush() {
+	m.endLine()
+
+	running := make([]*execution, 0, len(m.byStart))
+	for _, exec := range m.byStart {
+		running = append(running, exec)
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].step.Started.Before(running[j].step.Started)
+	})
+
+	for _, exec := range running {
+		m.closeIncomplete(exec)
+		m.events = append(m.events, Event{Type: EventStepCompleted, Step: exec.step})
+		m.forget(exec)
+	}
+}
+
+// drain returns the queued events and clears the queue
+func (m *vertexMerger) drain() []Event {
+	events := m.events
+	m.events = nil
+	return events
+}
+
+// recentSet is a set of the most recently added keys, up to a capacity. The
+// oldest keys are forgotten first.
+type recentSet struct {
+	keys  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newRecentSet(capacity int) *recentSet {
+	return &recentSet{
+		keys:  make(map[string]struct{}, capacity),
+		order: make([]string, 0, capacity),
+	}
+}
+
+// add adds a key, forgetting the oldest one if the set is full
+func (r *recentSet) add(key string) {
+	if _, ok := r.keys[key]; ok {
+		return
+	}
+	r.keys[key] = struct{}{}
+	if len(r.order) < cap(r.order) {
+		r.order = append(r.order, key)
+		return
+	}
+	delete(r.keys, r.order[r.next])
+	r.order[r.next] = key
+	r.next = (r.next + 1) % len(r.order)
+}
+
+// has reports whether the key is in the set
+func (r *recentSet) has(key string) bool {
+	_, ok := r.keys[key]
+	return ok
+}
@@ -0,0 +1,58 @@
+package buildx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParser_StreamStatusesWithCompletion(t *testing.T) {
+	// The completion arrives along with the final status of the step, and a
+	// late update of that status follows while the vertex runs again
+	jsonData := `
+	{"vertexes":[{"name":"FROM alpine", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}
+	{"vertexes":[{"name":"FROM alpine", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:02Z"}], "statuses":[{"id":"sha256:layer", "vertex":"sha256:abc", "current":10, "started":"2023-01-01T00:00:01Z", "completed":"2023-01-01T00:00:02Z"}], "logs":[{"vertex":"sha256:abc", "stream":1, "data":"ZG9uZQo="}]}
+	{"vertexes":[{"name":"FROM alpine", "digest":"sha256:abc", "started":"2023-01-01T00:00:03Z"}]}
+	{"statuses":[{"id":"sha256:layer", "vertex":"sha256:abc", "current":10, "started":"2023-01-01T00:00:01Z", "completed":"2023-01-01T00:00:02Z"}]}
+	{"vertexes":[{"name":"FROM alpine", "digest":"sha256:abc", "started":"2023-01-01T00:00:03Z", "completed":"2023-01-01T00:00:04Z"}]}
+	`
+
+	var completed []BuildStep
+	err := NewParser(strings.NewReader(jsonData)).Stream(func(event Event) error {
+		if event.Type == EventStepCompleted {
+			completed = append(completed, event.Step)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(completed) != 2 {
+		t.Fatalf("Expected 2 completed executions, got %d", len(completed))
+	}
+	if len(completed[0].Statuses) != 1 || len(completed[0].Logs) != 1 {
+		t.Errorf("Expected the status and output sent with the completion, got %d statuses and %d chunks",
+			len(completed[0].Statuses), len(completed[0].Logs))
+	}
+	if len(completed[1].Statuses) != 0 {
+		t.Errorf("Expected the late status update to be left out of the next execution, got %d statuses", len(completed[1].Statuses))
+	}
+}
+
+func TestRecentSet(t *testing.T) {
+	set := newRecentSet(3)
+	for i := 0; i < 5; i++ {
+		set.add(fmt.Sprintf("key-%d", i))
+	}
+	set.add("key-4")
+
+	for i, expected := range []bool{false, false, true, true, true} {
+		if has := set.has(fmt.Sprintf("key-%d", i)); has != expected {
+			t.Errorf("Expected key-%d in the set to be %v, got %v", i, expected, has)
+		}
+	}
+	if len(set.keys) != 3 {
+		t.Errorf("Expected the set to hold 3 keys, got %d", len(set.keys))
+	}
+}
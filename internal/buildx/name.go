@@ -0,0 +1,112 @@
+package buildx
+
+import (
+	"strconv"
+	"strings"
+)
+
+// InternalStage is the stage of vertices that do not belong to a Dockerfile
+// stage, such as loading the build definition or image metadata
+const InternalStage = "internal"
+
+// DefaultStage is the stage of the steps of a single-stage Dockerfile whose
+// stage is not named, such as "[1/3] FROM alpine"
+const DefaultStage = "stage-0"
+
+// VertexName is a vertex name broken down into its parts.
+// Names of Dockerfile steps look like "[stage-1 3/7] COPY go.mod go.sum ./",
+// optionally prefixed with the platform in multi-platform builds. Steps of a
+// single unnamed stage leave the stage out, as in "[linux/amd64 1/3] RUN make".
+type VertexName struct {
+	Platform    string
+	Stage       string
+	Index       int
+	Total       int
+	Instruction string
+}
+
+// ParseVertexName parses the stage, the step position and the instruction
+// out of a vertex name. Names of internal work, such as
+// "[internal] load build definition from Dockerfile" or "exporting to image",
+// get the internal stage.
+func ParseVertexName(name string) VertexName {
+	parsed := VertexName{
+		Stage:       InternalStage,
+		Instruction: strings.TrimSpace(name),
+	}
+
+	if !strings.HasPrefix(name, "[") {
+		return parsed
+	}
+	end := strings.Index(name, "]")
+	if end < 0 {
+		return parsed
+	}
+	parsed.Instruction = strings.TrimSpace(name[end+1:])
+
+	fields := strings.Fields(name[1:end])
+	if len(fields) == 0 {
+		return parsed
+	}
+
+	// The last field is the step position, e.g. "3/7"
+	index, total, found := strings.Cut(fields[len(fields)-1], "/")
+	if !found {
+		return parsed
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return parsed
+	}
+	n, err := strconv.Atoi(total)
+	if err != nil {
+		return parsed
+	}
+
+	// The field before the position is the stage, unless it is missing or
+	// is the platform, as stage names cannot contain a slash
+	prefix := fields[:len(fields)-1]
+	parsed.Stage = DefaultStage
+	if last := len(prefix) - 1; last >= 0 && !strings.Contains(prefix[last], "/") {
+		parsed.Stage = prefix[last]
+		prefix = prefix[:last]
+	}
+	parsed.Index = i
+	parsed.Total = n
+	parsed.Platform = strings.Join(prefix, " ")
+	return parsed
+}
+
+// Internal reports whether the vertex is internal work rather than a
+// Dockerfile step
+func (n VertexName) Internal() bool {
+	return n.Index == 0
+}
+
+// Group returns the name steps of the same stage are grouped under.
+// Stages of different platforms are kept apart.
+func (n VertexName) Group() string {
+	if n.Platform == "" || n.Internal() {
+		return n.Stage
+	}
+	return n.Platform + " " + n.Stage
+}
+
+// Command returns the instruction keyword, e.g. "RUN" or "COPY", or an empty
+// string for internal work
+func (n VertexName) Command() string {
+	if n.Internal() {
+		return ""
+	}
+	command, _, _ := strings.Cut(n.Instruction, " ")
+	return strings.ToUpper(command)
+}
+
+// Args returns the arguments of the instruction, e.g. "go.mod go.sum ./"
+func (n VertexName) Args() string {
+	if n.Internal() {
+		return n.Instruction
+	}
+	_, args, _ := strings.Cut(n.Instruction, " ")
+	return strings.TrimSpace(args)
+}
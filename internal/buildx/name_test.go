@@ -0,0 +1,115 @@
+package buildx
+
+import (
+	"testing"
+)
+
+func TestParseVertexName(t *testing.T) {
+	tests := []struct {
+		name        string
+		platform    string
+		stage       string
+		group       string
+		index       int
+		total       int
+		instruction string
+		command     string
+		args        string
+	}{
+		{
+			name:        "[stage-1 3/7] COPY go.mod go.sum ./",
+			stage:       "stage-1",
+			group:       "stage-1",
+			index:       3,
+			total:       7,
+			instruction: "COPY go.mod go.sum ./",
+			command:     "COPY",
+			args:        "go.mod go.sum ./",
+		},
+		{
+			name:        "[wrench 1/1] FROM ghcr.io/cloudspannerecosystem/wrench:1.11.3",
+			stage:       "wrench",
+			group:       "wrench",
+			index:       1,
+			total:       1,
+			instruction: "FROM ghcr.io/cloudspannerecosystem/wrench:1.11.3",
+			command:     "FROM",
+			args:        "ghcr.io/cloudspannerecosystem/wrench:1.11.3",
+		},
+		{
+			name:        "[linux/arm64 stage-0 2/6] WORKDIR /app",
+			platform:    "linux/arm64",
+			stage:       "stage-0",
+			group:       "linux/arm64 stage-0",
+			index:       2,
+			total:       6,
+			instruction: "WORKDIR /app",
+			command:     "WORKDIR",
+			args:        "/app",
+		},
+		{
+			name:        "[1/3] FROM docker.io/library/alpine:3.20",
+			stage:       DefaultStage,
+			group:       DefaultStage,
+			index:       1,
+			total:       3,
+			instruction: "FROM docker.io/library/alpine:3.20",
+			command:     "FROM",
+			args:        "docker.io/library/alpine:3.20",
+		},
+		{
+			name:        "[linux/amd64 3/3] RUN make",
+			platform:    "linux/amd64",
+			stage:       DefaultStage,
+			group:       "linux/amd64 " + DefaultStage,
+			index:       3,
+			total:       3,
+			instruction: "RUN make",
+			command:     "RUN",
+			args:        "make",
+		},
+		{
+			name:        "[internal] load build definition from Dockerfile",
+			stage:       InternalStage,
+			group:       InternalStage,
+			instruction: "load build definition from Dockerfile",
+			args:        "load build definition from Dockerfile",
+		},
+		{
+			name:        "exporting to image",
+			stage:       InternalStage,
+			group:       InternalStage,
+			instruction: "exporting to image",
+			args:        "exporting to image",
+		},
+	}
+
+	for _, tt := range tests {
+		parsed := ParseVertexName(tt.name)
+
+		if parsed.Platform != tt.platform {
+			t.Errorf("%q: expected platform '%s', got '%s'", tt.name, tt.platform, parsed.Platform)
+		}
+		if parsed.Stage != tt.stage {
+			t.Errorf("%q: expected stage '%s', got '%s'", tt.name, tt.stage, parsed.Stage)
+		}
+		if parsed.Group() != tt.group {
+			t.Errorf("%q: expected group '%s', got '%s'", tt.name, tt.group, parsed.Group())
+		}
+		if parsed.Index != tt.index || parsed.Total != tt.total {
+			t.Errorf("%q: expected step %d/%d, got %d/%d", tt.name, tt.index, tt.total, parsed.Index, parsed.Total)
+		}
+		if parsed.Instruction != tt.instruction {
+			t.Errorf("%q: expected instruction '%s', got '%s'", tt.name, tt.instruction, parsed.Instruction)
+		}
+		if parsed.Command() != tt.command {
+			t.Errorf("%q: expected command '%s', got '%s'", tt.name, tt.command, parsed.Command())
+		}
+		if parsed.Args() != tt.args {
+			t.Errorf("%q: expected args '%s', got '%s'", tt.name, tt.args, parsed.Args())
+		}
+		if parsed.Internal() != (tt.index == 0) {
+			t.Errorf("%q: expected internal to be %v", tt.name, tt.index == 0)
+		}
+	}
+}
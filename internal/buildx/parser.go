@@ -2,9 +2,11 @@ package buildx
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/sakajunquality/buildx-telemetry/internal/logger"
@@ -13,10 +15,58 @@ import (
 
 // BuildStep represents a single build step in the Docker build process
 type BuildStep struct {
+	Digest    string
 	Name      string
+	Inputs    []string
 	Started   time.Time
 	Completed time.Time
 	Cached    bool
+	Statuses  []Status
+	Logs      []LogChunk
+
+	// Error is the error the step failed with, if any
+	Error string
+	// Incomplete is set for steps that never completed, for example because
+	// the build was interrupted. Completed is the last time seen in the log.
+	Incomplete bool
+}
+
+// Status is an operation that ran within a build step, merged from all of
+// its progress updates
+type Status struct {
+	ID        string
+	Name      string
+	Current   int64
+	Total     int64
+	Started   time.Time
+	Completed time.Time
+}
+
+// Operation returns the kind of work the status reports on, such as
+// "transferring", "extracting", "downloading" or "resolve"
+func (s Status) Operation() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if strings.HasPrefix(s.ID, "sha256:") {
+		return "downloading"
+	}
+	if i := strings.IndexByte(s.ID, ' '); i > 0 {
+		return s.ID[:i]
+	}
+	return s.ID
+}
+
+// Transfer reports whether the operation moves bytes, such as a layer
+// download or a context transfer, rather than working on them locally like
+// an extraction
+func (s Status) Transfer() bool {
+	switch s.Operation() {
+	case "downloading", "transferring":
+		return true
+	default:
+		return false
+	}
 }
 
 // Vertex represents a vertex in the build graph from buildx json output
@@ -27,26 +77,215 @@ type Vertex struct {
 	Completed string   `json:"completed,omitempty"`
 	Inputs    []string `json:"inputs,omitempty"`
 	Cached    bool     `json:"cached,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// VertexStatus is a progress update of an operation running within a vertex,
+// such as a layer download, an extraction or a context transfer
+type VertexStatus struct {
+	ID        string `json:"id"`
+	Vertex    string `json:"vertex"`
+	Name      string `json:"name"`
+	Current   int64  `json:"current"`
+	Total     int64  `json:"total,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Started   string `json:"started,omitempty"`
+	Completed string `json:"completed,omitempty"`
+}
+
+// VertexLog is a chunk of output written by a vertex. Data is base64 encoded
+// in the log and decoded on unmarshaling.
+type VertexLog struct {
+	Vertex    string `json:"vertex"`
+	Stream    int    `json:"stream"`
+	Data      []byte `json:"data"`
+	Timestamp string `json:"timestamp"`
+}
+
+// VertexWarning is a warning emitted for a vertex, such as a Dockerfile lint
+// rule violation. Short and Detail are base64 encoded in the log and decoded
+// on unmarshaling.
+type VertexWarning struct {
+	Vertex     string        `json:"vertex"`
+	Level      int           `json:"level"`
+	Short      []byte        `json:"short"`
+	Detail     [][]byte      `json:"detail,omitempty"`
+	URL        string        `json:"url,omitempty"`
+	SourceInfo *SourceInfo   `json:"sourceInfo,omitempty"`
+	Range      []SourceRange `json:"range,omitempty"`
+}
+
+// SourceInfo describes the source file a warning refers to
+type SourceInfo struct {
+	Filename string `json:"filename"`
+	Language string `json:"language,omitempty"`
+}
+
+// SourceRange is a range of lines in a source file
+type SourceRange struct {
+	Start SourcePosition `json:"start"`
+	End   SourcePosition `json:"end"`
+}
+
+// SourcePosition is a position in a source file
+type SourcePosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character,omitempty"`
 }
 
 // LogEntry is the buildx build log output with --progress=rawjson option
 type LogEntry struct {
-	Vertexes []Vertex `json:"vertexes,omitempty"`
-	Statuses []struct {
-		ID        string `json:"id"`
-		Vertex    string `json:"vertex"`
-		Name      string `json:"name"`
-		Current   int    `json:"current"`
-		Timestamp string `json:"timestamp"`
-		Started   string `json:"started,omitempty"`
-		Completed string `json:"completed,omitempty"`
-	} `json:"statuses,omitempty"`
+	Vertexes []Vertex        `json:"vertexes,omitempty"`
+	Statuses []VertexStatus  `json:"statuses,omitempty"`
+	Logs     []VertexLog     `json:"logs,omitempty"`
+	Warnings []VertexWarning `json:"warnings,omitempty"`
+}
+
+// Output streams of a vertex log
+const (
+	StreamStdout = 1
+	StreamStderr = 2
+)
+
+// LogChunk is a piece of output written by a build step
+type LogChunk struct {
+	Stream    int
+	Data      []byte
+	Timestamp time.Time
+}
+
+// StreamName returns the name of the stream the chunk was written to
+func (c LogChunk) StreamName() string {
+	return streamName(c.Stream)
+}
+
+// LogLine is a line of output written by a build step, which may have been
+// written over several chunks
+type LogLine struct {
+	Stream int
+	Text   string
+	// Timestamp is the time of the chunk the line started in
+	Timestamp time.Time
+}
+
+// StreamName returns the name of the stream the line was written to
+func (l LogLine) StreamName() string {
+	return streamName(l.Stream)
+}
+
+// streamName returns the name of an output stream
+func streamName(stream int) string {
+	switch stream {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	default:
+		return fmt.Sprintf("stream-%d", stream)
+	}
+}
+
+// SplitLines splits the output of a step into lines, without line
+// terminators. A line that is continued in a later chunk of the same stream
+// is joined rather than split at the chunk boundary. Empty lines at the end
+// of the output are left out.
+func SplitLines(chunks []LogChunk) []LogLine {
+	var lines []LogLine
+	// unterminated holds the index of the last line of each stream while it
+	// has not ended with a newline yet
+	unterminated := make(map[int]int)
+	for _, chunk := range chunks {
+		pieces := strings.Split(string(chunk.Data), "\n")
+		for i, piece := range pieces {
+			last := i == len(pieces)-1
+			if last && piece == "" {
+				break
+			}
+
+			index, ok := unterminated[chunk.Stream]
+			if ok && i == 0 {
+				lines[index].Text += piece
+			} else {
+				lines = append(lines, LogLine{Stream: chunk.Stream, Text: piece, Timestamp: chunk.Timestamp})
+				index = len(lines) - 1
+			}
+
+			if last {
+				unterminated[chunk.Stream] = index
+			} else {
+				delete(unterminated, chunk.Stream)
+			}
+		}
+	}
+
+	for i := range lines {
+		lines[i].Text = strings.TrimRight(lines[i].Text, "\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1].Text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Warning is a build warning with its decoded message and source location
+type Warning struct {
+	Vertex    string
+	Level     int
+	Message   string
+	Detail    []string
+	URL       string
+	Filename  string
+	StartLine int
+	EndLine   int
+}
+
+// newWarning decodes a warning from the build log
+func newWarning(w VertexWarning) Warning {
+	warning := Warning{
+		Vertex:  w.Vertex,
+		Level:   w.Level,
+		Message: string(w.Short),
+		URL:     w.URL,
+	}
+	for _, detail := range w.Detail {
+		warning.Detail = append(warning.Detail, string(detail))
+	}
+	if w.SourceInfo != nil {
+		warning.Filename = w.SourceInfo.Filename
+	}
+	if len(w.Range) > 0 {
+		warning.StartLine = w.Range[0].Start.Line
+		warning.EndLine = w.Range[len(w.Range)-1].End.Line
+	}
+	return warning
+}
+
+// Rule returns the name of the lint rule that produced the warning,
+// e.g. "FromAsCasing", or an empty string if the message names none
+func (w Warning) Rule() string {
+	rule, _, found := strings.Cut(w.Message, ": ")
+	if !found || strings.ContainsAny(rule, " \t") {
+		return ""
+	}
+	return rule
+}
+
+// Location returns the source location of the warning, e.g. "Dockerfile:24"
+func (w Warning) Location() string {
+	if w.Filename == "" {
+		return ""
+	}
+	if w.StartLine == 0 {
+		return w.Filename
+	}
+	return fmt.Sprintf("%s:%d", w.Filename, w.StartLine)
 }
 
 // Parser handles parsing buildx logs
 type Parser struct {
-	reader io.Reader
-	logger logger.Logger
+	reader   io.Reader
+	logger   logger.Logger
+	warnings []Warning
 }
 
 // NewParser creates a new buildx log parser
@@ -67,77 +306,231 @@ func NewParserWithLogger(reader io.Reader, log logger.Logger) *Parser {
 	}
 }
 
-// Parse reads the log stream and returns a slice of BuildStep
+// maxLineSize is the longest log line the parser accepts. Lines carrying
+// step output or warnings with their Dockerfile source can be large.
+const maxLineSize = 16 * 1024 * 1024
+
+// Parse reads the log stream and returns a slice of BuildStep.
+// Vertex updates are merged by digest, so every execution of a vertex
+// results in exactly one step.
 func (p *Parser) Parse() ([]BuildStep, error) {
-	var steps []BuildStep
+	merger := newVertexMerger()
+	p.warnings = nil
+
+	err := p.scan(merger, func(warning Warning) {
+		p.warnings = append(p.warnings, warning)
+	}, nil)
+
+	steps := merger.steps()
+	for _, step := range steps {
+		p.logger.Debug("Parsed build step",
+			zap.String("step", step.Name),
+			zap.Duration("duration", step.Completed.Sub(step.Started)),
+			zap.Bool("cached", step.Cached),
+			zap.Int("statuses", len(step.Statuses)),
+			zap.Int("logs", len(step.Logs)))
+	}
+
+	return steps, err
+}
+
+// Stream reads the log stream and calls handle for every build event as soon
+// as it is seen, so that steps can be exported while the build is running.
+// Completed steps are not retained, which keeps memory bounded for very
+// large logs. Steps still running when the stream ends are sent as
+// incomplete. An error returned by handle stops the stream.
+func (p *Parser) Stream(handle func(Event) error) error {
+	return p.StreamContext(context.Background(), handle)
+}
+
+// StreamContext is like Stream, but also stops when ctx is done, even while
+// waiting for the next line of the log. The steps still running are then sent
+// as incomplete and the error of ctx is returned, without waiting for the
+// pending read, which a blocking reader such as stdin may never finish.
+func (p *Parser) StreamContext(ctx context.Context, handle func(Event) error) error {
+	merger := newStreamingVertexMerger()
+
+	var handleErr error
+	drain := func() error {
+		for _, event := range merger.drain() {
+			if handleErr = handle(event); handleErr != nil {
+				return handleErr
+			}
+		}
+		return nil
+	}
+
+	scanned := make(chan error, 1)
+	go func() {
+		scanned <- p.scan(merger, merger.addWarning, drain)
+	}()
+
+	var err error
+	select {
+	case err = <-scanned:
+	case <-ctx.Done():
+		// Stop between two lines, so that the line being merged, if any,
+		// is finished first
+		merger.mu.Lock()
+		defer merger.mu.Unlock()
+		merger.stopped = true
+		err = ctx.Err()
+	}
+	if handleErr != nil {
+		return handleErr
+	}
+
+	merger.flush()
+	if drainErr := drain(); drainErr != nil {
+		return drainErr
+	}
+	return err
+}
+
+// scan reads the log stream line by line and feeds every record into the
+// merger. If afterLine is not nil it runs after each line, and an error it
+// returns stops the scan.
+func (p *Parser) scan(merger *vertexMerger, onWarning func(Warning), afterLine func() error) error {
 	scanner := bufio.NewScanner(p.reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
 	lineCount := 0
 	vertexCount := 0
+	statusCount := 0
+	logCount := 0
+	warningCount := 0
 
 	p.logger.Debug("Starting to parse buildx log")
 
 	for scanner.Scan() {
+		// A stopped stream has sent its events, so the line is dropped
+		merger.mu.Lock()
+		if merger.stopped {
+			merger.mu.Unlock()
+			return nil
+		}
+
 		lineCount++
 		var entry LogEntry
-		line := scanner.Text()
+		line := scanner.Bytes()
 
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		if err := json.Unmarshal(line, &entry); err != nil {
 			p.logger.Debug("Failed to parse log line",
 				zap.Int("line", lineCount),
 				zap.Error(err))
+			merger.mu.Unlock()
 			continue
 		}
 
 		for _, vertex := range entry.Vertexes {
 			vertexCount++
-			if vertex.Started != "" && vertex.Completed != "" {
-				started, err := time.Parse(time.RFC3339Nano, vertex.Started)
-				if err != nil {
-					p.logger.Debug("Failed to parse start time",
-						zap.String("vertex", vertex.Name),
-						zap.Error(err))
-					continue
-				}
-				completed, err := time.Parse(time.RFC3339Nano, vertex.Completed)
-				if err != nil {
-					p.logger.Debug("Failed to parse completion time",
-						zap.String("vertex", vertex.Name),
-						zap.Error(err))
-					continue
-				}
-
-				duration := completed.Sub(started)
-				step := BuildStep{
-					Name:      vertex.Name,
-					Started:   started,
-					Completed: completed,
-					Cached:    vertex.Cached,
-				}
-				steps = append(steps, step)
-
-				p.logger.Debug("Parsed build step",
-					zap.String("step", vertex.Name),
-					zap.Duration("duration", duration),
-					zap.Bool("cached", vertex.Cached))
+			if err := merger.add(vertex); err != nil {
+				p.logger.Debug("Failed to merge vertex update",
+					zap.String("vertex", vertex.Name),
+					zap.Int("line", lineCount),
+					zap.Error(err))
+			}
+		}
+
+		for _, status := range entry.Statuses {
+			statusCount++
+			if err := merger.addStatus(status); err != nil {
+				p.logger.Debug("Failed to merge status update",
+					zap.String("status", status.ID),
+					zap.Int("line", lineCount),
+					zap.Error(err))
 			}
 		}
+
+		for _, log := range entry.Logs {
+			logCount++
+			if err := merger.addLog(log); err != nil {
+				p.logger.Debug("Failed to merge log output",
+					zap.String("vertex", log.Vertex),
+					zap.Int("line", lineCount),
+					zap.Error(err))
+			}
+		}
+
+		for _, w := range entry.Warnings {
+			warningCount++
+			warning := newWarning(w)
+			onWarning(warning)
+			p.logger.Debug("Parsed build warning",
+				zap.String("warning", warning.Message),
+				zap.String("location", warning.Location()))
+		}
+
+		merger.endLine()
+		if afterLine != nil {
+			if err := afterLine(); err != nil {
+				merger.mu.Unlock()
+				return err
+			}
+		}
+		merger.mu.Unlock()
 	}
 
 	p.logger.Info("Completed parsing build log",
 		zap.Int("lines", lineCount),
 		zap.Int("vertexes", vertexCount),
-		zap.Int("steps", len(steps)))
+		zap.Int("statuses", statusCount),
+		zap.Int("logs", logCount),
+		zap.Int("warnings", warningCount))
 
-	return steps, scanner.Err()
+	return scanner.Err()
+}
+
+// ParseGraph reads the log stream and returns the build steps as a
+// dependency graph keyed by vertex digest, along with the build warnings
+func (p *Parser) ParseGraph() (*Graph, error) {
+	steps, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := NewGraph(steps)
+	graph.warnings = p.warnings
+	return graph, nil
+}
+
+// Warnings returns the warnings found by the last call to Parse
+func (p *Parser) Warnings() []Warning {
+	return p.warnings
 }
 
 // PrintSteps prints the build steps in a human-readable format
 func PrintSteps(steps []BuildStep) {
 	for _, step := range steps {
-		fmt.Printf("%s\nStarted: %s\nCompleted: %s\nCached: %v\n\n",
+		fmt.Printf("%s\nStarted: %s\nCompleted: %s\nCached: %v\n",
 			step.Name,
 			step.Started.Format(time.RFC3339Nano),
 			step.Completed.Format(time.RFC3339Nano),
 			step.Cached)
+		if step.Incomplete {
+			fmt.Printf("Incomplete: true\n")
+		}
+		if step.Error != "" {
+			fmt.Printf("Error: %s\n", step.Error)
+		}
+		fmt.Println()
+	}
+}
+
+// PrintWarnings prints a summary of the build warnings
+func PrintWarnings(warnings []Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	fmt.Printf("Warnings: %d\n", len(warnings))
+	for _, warning := range warnings {
+		if location := warning.Location(); location != "" {
+			fmt.Printf("  %s: %s\n", location, warning.Message)
+		} else {
+			fmt.Printf("  %s\n", warning.Message)
+		}
+		if warning.URL != "" {
+			fmt.Printf("    %s\n", warning.URL)
+		}
 	}
 }
@@ -1,6 +1,10 @@
 package buildx
 
 import (
+	"context"
+	"errors"
+	"io"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -61,3 +65,408 @@ func TestParser_ParseValidJson(t *testing.T) {
 		t.Errorf("Expected start time %v, got %v", expectedStart, steps[0].Started)
 	}
 }
+
+func TestParser_MergeVertexUpdates(t *testing.T) {
+	jsonData := `
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc"}]}
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc", "inputs":["sha256:def"], "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:05Z"}]}
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:05Z"}]}
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc", "started":"2023-01-01T00:00:06Z"}]}
+	{"vertexes":[{"name":"step1", "digest":"sha256:abc", "completed":"2023-01-01T00:00:08Z"}]}
+	`
+
+	steps, err := NewParser(strings.NewReader(jsonData)).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(steps) != 2 {
+		t.Fatalf("Expected 2 executions, got %d steps", len(steps))
+	}
+
+	if got := steps[0].Completed.Sub(steps[0].Started); got != 5*time.Second {
+		t.Errorf("Expected first execution to take 5s, got %v", got)
+	}
+
+	if len(steps[0].Inputs) != 1 || steps[0].Inputs[0] != "sha256:def" {
+		t.Errorf("Expected inputs to be merged, got %v", steps[0].Inputs)
+	}
+
+	if got := steps[1].Completed.Sub(steps[1].Started); got != 2*time.Second {
+		t.Errorf("Expected second execution to take 2s, got %v", got)
+	}
+}
+
+func TestParser_ParseLogFile(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	steps, err := NewParser(file).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(steps) != 54 {
+		t.Errorf("Expected 54 executions, got %d", len(steps))
+	}
+
+	seen := make(map[string]bool)
+	digests := make(map[string]bool)
+	for _, step := range steps {
+		key := step.Digest + "@" + step.Started.String()
+		if seen[key] {
+			t.Errorf("Duplicate execution of %s started at %v", step.Name, step.Started)
+		}
+		seen[key] = true
+		digests[step.Digest] = true
+
+		if step.Completed.Before(step.Started) {
+			t.Errorf("Step %s completed before it started", step.Name)
+		}
+	}
+
+	if len(digests) != 25 {
+		t.Errorf("Expected 25 vertexes, got %d", len(digests))
+	}
+}
+
+func TestParser_MergeStatuses(t *testing.T) {
+	jsonData := `
+	{"vertexes":[{"name":"FROM golang", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}
+	{"statuses":[{"id":"sha256:layer", "vertex":"sha256:abc", "current":0, "total":1000, "timestamp":"2023-01-01T00:00:01Z", "started":"2023-01-01T00:00:01Z"}]}
+	{"statuses":[{"id":"sha256:layer", "vertex":"sha256:abc", "current":500, "total":1000, "timestamp":"2023-01-01T00:00:02Z", "started":"2023-01-01T00:00:01Z"}]}
+	{"statuses":[{"id":"sha256:layer", "vertex":"sha256:abc", "current":1000, "total":1000, "timestamp":"2023-01-01T00:00:03Z", "started":"2023-01-01T00:00:01Z", "completed":"2023-01-01T00:00:03Z"}]}
+	{"statuses":[{"id":"extracting sha256:layer", "vertex":"sha256:abc", "name":"extracting", "current":0, "timestamp":"2023-01-01T00:00:04Z", "started":"2023-01-01T00:00:03Z"}]}
+	{"vertexes":[{"name":"FROM golang", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:05Z"}]}
+	`
+
+	steps, err := NewParser(strings.NewReader(jsonData)).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(steps) != 1 {
+		t.Fatalf("Expected 1 step, got %d steps", len(steps))
+	}
+
+	statuses := steps[0].Statuses
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %d", len(statuses))
+	}
+
+	download := statuses[0]
+	if download.Operation() != "downloading" {
+		t.Errorf("Expected download operation, got '%s'", download.Operation())
+	}
+	if !download.Transfer() || statuses[1].Transfer() {
+		t.Errorf("Expected the download to be a transfer and the extraction not")
+	}
+	if download.Current != 1000 || download.Total != 1000 {
+		t.Errorf("Expected 1000/1000 bytes, got %d/%d", download.Current, download.Total)
+	}
+	if got := download.Completed.Sub(download.Started); got != 2*time.Second {
+		t.Errorf("Expected download to take 2s, got %v", got)
+	}
+
+	// The extraction never completed, so it ends at its last update
+	extract := statuses[1]
+	if extract.Operation() != "extracting" {
+		t.Errorf("Expected extracting operation, got '%s'", extract.Operation())
+	}
+	if got := extract.Completed.Sub(extract.Started); got != time.Second {
+		t.Errorf("Expected extraction to take 1s, got %v", got)
+	}
+}
+
+func TestParser_ParseLogs(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	steps, err := NewParser(file).Parse()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var install *BuildStep
+	for i := range steps {
+		if steps[i].Name == "[stage-0 4/6] RUN npm install" {
+			install = &steps[i]
+		}
+	}
+	if install == nil {
+		t.Fatalf("Expected to find the npm install step")
+	}
+
+	if len(install.Logs) == 0 {
+		t.Fatalf("Expected output for the npm install step")
+	}
+
+	chunk := install.Logs[0]
+	if chunk.StreamName() != "stderr" {
+		t.Errorf("Expected output on stderr, got %s", chunk.StreamName())
+	}
+	if lines := SplitLines(install.Logs); len(lines) == 0 || !strings.HasPrefix(lines[0].Text, "npm warn deprecated") {
+		t.Errorf("Expected decoded npm output, got %v", lines)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	base := time.Unix(1000, 0)
+	lines := SplitLines([]LogChunk{
+		{Stream: StreamStdout, Data: []byte("first\r\nsec"), Timestamp: base},
+		{Stream: StreamStderr, Data: []byte("warning\n"), Timestamp: base.Add(time.Second)},
+		{Stream: StreamStdout, Data: []byte("ond\n\n"), Timestamp: base.Add(2 * time.Second)},
+	})
+
+	expected := []LogLine{
+		{Stream: StreamStdout, Text: "first", Timestamp: base},
+		{Stream: StreamStdout, Text: "second", Timestamp: base},
+		{Stream: StreamStderr, Text: "warning", Timestamp: base.Add(time.Second)},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d: %v", len(expected), len(lines), lines)
+	}
+	for i, line := range lines {
+		if line.Stream != expected[i].Stream || line.Text != expected[i].Text || !line.Timestamp.Equal(expected[i].Timestamp) {
+			t.Errorf("Expected line %d to be %v, got %v", i, expected[i], line)
+		}
+	}
+
+	if lines := SplitLines([]LogChunk{{}}); len(lines) != 0 {
+		t.Errorf("Expected no lines for empty output, got %v", lines)
+	}
+}
+
+func TestParser_ParseWarnings(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	graph, err := NewParser(file).ParseGraph()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	warnings := graph.Warnings()
+	if len(warnings) != 3 {
+		t.Fatalf("Expected 3 warnings, got %d", len(warnings))
+	}
+
+	warning := warnings[0]
+	if warning.Rule() != "FromAsCasing" {
+		t.Errorf("Expected FromAsCasing rule, got '%s'", warning.Rule())
+	}
+	if warning.Location() != "Dockerfile:24" {
+		t.Errorf("Expected location Dockerfile:24, got '%s'", warning.Location())
+	}
+	if warning.URL != "https://docs.docker.com/go/dockerfile/rule/from-as-casing/" {
+		t.Errorf("Unexpected warning URL '%s'", warning.URL)
+	}
+	if len(warning.Detail) != 1 || !strings.HasPrefix(warning.Detail[0], "The 'as' keyword") {
+		t.Errorf("Expected decoded detail, got %q", warning.Detail)
+	}
+}
+
+func TestParser_FailedAndIncompleteSteps(t *testing.T) {
+	jsonData := `
+	{"vertexes":[{"name":"RUN make", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}
+	{"vertexes":[{"name":"RUN make", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:05Z", "error":"process \"/bin/sh -c make\" did not complete successfully: exit code: 2"}]}
+	{"vertexes":[{"name":"RUN test", "digest":"sha256:def", "started":"2023-01-01T00:00:01Z"}]}
+	{"statuses":[{"id":"running", "vertex":"sha256:def", "current":0, "timestamp":"2023-01-01T00:00:07Z", "started":"2023-01-01T00:00:02Z"}]}
+	`
+
+	graph, err := NewParser(strings.NewReader(jsonData)).ParseGraph()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	failed, ok := graph.Step("sha256:abc")
+	if !ok {
+		t.Fatalf("Expected failed step to be parsed")
+	}
+	if !strings.Contains(failed.Error, "exit code: 2") {
+		t.Errorf("Expected step error, got '%s'", failed.Error)
+	}
+
+	unfinished, ok := graph.Step("sha256:def")
+	if !ok {
+		t.Fatalf("Expected unfinished step to be parsed")
+	}
+	if !unfinished.Incomplete {
+		t.Errorf("Expected unfinished step to be flagged as incomplete")
+	}
+	if got := unfinished.Completed.Sub(unfinished.Started); got != 6*time.Second {
+		t.Errorf("Expected unfinished step to end at the last seen timestamp, got %v", got)
+	}
+
+	if graph.Outcome() != OutcomeFailed {
+		t.Errorf("Expected failed outcome, got '%s'", graph.Outcome())
+	}
+}
+
+func TestParser_FailedBeforeStarting(t *testing.T) {
+	jsonData := `
+	{"vertexes":[{"name":"RUN make", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z", "completed":"2023-01-01T00:00:02Z"}]}
+	{"vertexes":[{"name":"FROM example.com/missing", "digest":"sha256:def"}]}
+	{"vertexes":[{"name":"FROM example.com/missing", "digest":"sha256:def", "error":"example.com/missing: not found"}]}
+	{"vertexes":[{"name":"FROM example.com/missing", "digest":"sha256:def", "error":"example.com/missing: not found"}]}
+	`
+
+	for _, streaming := range []bool{false, true} {
+		var steps []BuildStep
+		var err error
+		if streaming {
+			err = NewParser(strings.NewReader(jsonData)).Stream(func(event Event) error {
+				if event.Type == EventStepCompleted {
+					steps = append(steps, event.Step)
+				}
+				return nil
+			})
+		} else {
+			steps, err = NewParser(strings.NewReader(jsonData)).Parse()
+		}
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(steps) != 2 {
+			t.Fatalf("Expected the failed vertex as a step once (streaming: %v), got %d steps", streaming, len(steps))
+		}
+		failed := steps[1]
+		if failed.Name != "FROM example.com/missing" || !strings.Contains(failed.Error, "not found") {
+			t.Errorf("Expected the failed vertex with its error (streaming: %v), got %+v", streaming, failed)
+		}
+		if failed.Incomplete || !failed.Started.Equal(steps[0].Completed) || !failed.Completed.Equal(failed.Started) {
+			t.Errorf("Expected the failed vertex at the last time seen (streaming: %v), got %v to %v", streaming, failed.Started, failed.Completed)
+		}
+	}
+}
+
+func TestParser_Stream(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	counts := make(map[EventType]int)
+	started := make(map[string]bool)
+	err = NewParser(file).Stream(func(event Event) error {
+		counts[event.Type]++
+
+		key := event.Step.Digest + "@" + event.Step.Started.String()
+		switch event.Type {
+		case EventStepStarted:
+			started[key] = true
+		case EventStepCompleted:
+			if !started[key] {
+				t.Errorf("Step %s completed before it started", event.Step.Name)
+			}
+			if event.Step.Completed.IsZero() {
+				t.Errorf("Step %s completed without a completion time", event.Step.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if counts[EventStepStarted] != 54 || counts[EventStepCompleted] != 54 {
+		t.Errorf("Expected 54 started and completed steps, got %d and %d",
+			counts[EventStepStarted], counts[EventStepCompleted])
+	}
+	if counts[EventWarning] != 3 {
+		t.Errorf("Expected 3 warnings, got %d", counts[EventWarning])
+	}
+}
+
+func TestParser_StreamIncompleteAndHandlerError(t *testing.T) {
+	jsonData := `
+	{"vertexes":[{"name":"RUN make", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}
+	{"vertexes":[{"name":"RUN test", "digest":"sha256:def", "started":"2023-01-01T00:00:01Z", "completed":"2023-01-01T00:00:03Z"}]}
+	`
+
+	var completed []BuildStep
+	err := NewParser(strings.NewReader(jsonData)).Stream(func(event Event) error {
+		if event.Type == EventStepCompleted {
+			completed = append(completed, event.Step)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(completed) != 2 {
+		t.Fatalf("Expected 2 completed steps, got %d", len(completed))
+	}
+	if completed[0].Name != "RUN test" || completed[0].Incomplete {
+		t.Errorf("Expected RUN test to complete first, got %s", completed[0].Name)
+	}
+	if completed[1].Name != "RUN make" || !completed[1].Incomplete {
+		t.Errorf("Expected RUN make to be closed as incomplete at the end")
+	}
+
+	stop := errors.New("stop")
+	events := 0
+	err = NewParser(strings.NewReader(jsonData)).Stream(func(event Event) error {
+		events++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+	if events != 1 {
+		t.Errorf("Expected the stream to stop after the first event, got %d events", events)
+	}
+}
+
+func TestParser_StreamContextStopsWhileReading(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var completed []BuildStep
+	done := make(chan error, 1)
+	go func() {
+		done <- NewParser(reader).StreamContext(ctx, func(event Event) error {
+			switch event.Type {
+			case EventStepStarted:
+				close(started)
+			case EventStepCompleted:
+				completed = append(completed, event.Step)
+			}
+			return nil
+		})
+	}()
+
+	// The writer stays open, so the parser blocks reading the next line
+	if _, err := writer.Write([]byte(`{"vertexes":[{"name":"RUN make", "digest":"sha256:abc", "started":"2023-01-01T00:00:00Z"}]}` + "\n")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the error of the context, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream to stop without waiting for the read")
+	}
+	if len(completed) != 1 || completed[0].Name != "RUN make" || !completed[0].Incomplete {
+		t.Errorf("Expected the running step to be sent as incomplete, got %v", completed)
+	}
+}
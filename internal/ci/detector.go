@@ -0,0 +1,290 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// CI/CD and VCS semantic convention attributes filled in by the detectors
+const (
+	PipelineName   = attribute.Key("cicd.pipeline.name")
+	PipelineRunID  = attribute.Key("cicd.pipeline.run.id")
+	PipelineRunURL = attribute.Key("cicd.pipeline.run.url.full")
+	RunAttempt     = attribute.Key("cicd.pipeline.run.attempt")
+	TaskName       = attribute.Key("cicd.pipeline.task.name")
+	TaskRunID      = attribute.Key("cicd.pipeline.task.run.id")
+	WorkerName     = attribute.Key("cicd.worker.name")
+	RepositoryURL  = attribute.Key("vcs.repository.url.full")
+	RepositoryName = attribute.Key("vcs.repository.name")
+	Revision       = attribute.Key("vcs.ref.head.revision")
+	Branch         = attribute.Key("vcs.ref.head.name")
+)
+
+// Names of the detectors, and the special values of Resolve
+const (
+	GitHubActions = "github"
+	CloudBuild    = "cloudbuild"
+	GitLab        = "gitlab"
+	CircleCI      = "circleci"
+	Buildkite     = "buildkite"
+	Jenkins       = "jenkins"
+
+	Auto = "auto"
+	None = "none"
+)
+
+// Lookup looks up an environment variable, like os.LookupEnv
+type Lookup func(key string) (string, bool)
+
+// get returns the value of an environment variable, or "" if it is unset
+func (l Lookup) get(key string) string {
+	value, _ := l(key)
+	return value
+}
+
+// Detector is a resource detector describing the CI job a build runs in
+type Detector struct {
+	name       string
+	lookup     Lookup
+	detected   func(env Lookup) bool
+	attributes func(env Lookup) []attribute.KeyValue
+}
+
+var _ resource.Detector = (*Detector)(nil)
+
+// Name returns the name the detector is selected with
+func (d *Detector) Name() string {
+	return d.name
+}
+
+// Detected reports whether the build runs in the CI system of the detector
+func (d *Detector) Detected() bool {
+	return d.detected(d.lookup)
+}
+
+// Detect returns the attributes of the CI job as a resource, or an empty
+// resource outside of the CI system. Unset variables are left out.
+func (d *Detector) Detect(ctx context.Context) (*resource.Resource, error) {
+	if !d.Detected() {
+		return resource.Empty(), nil
+	}
+
+	var attrs []attribute.KeyValue
+	for _, attr := range d.attributes(d.lookup) {
+		if attr.Value.AsString() != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
+
+// Detectors returns the detectors of all supported CI systems
+func Detectors(lookup Lookup) []*Detector {
+	return []*Detector{
+		{name: GitHubActions, lookup: lookup, detected: isTrue("GITHUB_ACTIONS"), attributes: githubActions},
+		{name: GitLab, lookup: lookup, detected: isTrue("GITLAB_CI"), attributes: gitlab},
+		{name: CircleCI, lookup: lookup, detected: isTrue("CIRCLECI"), attributes: circleCI},
+		{name: Buildkite, lookup: lookup, detected: isTrue("BUILDKITE"), attributes: buildkite},
+		{name: Jenkins, lookup: lookup, detected: isSet("JENKINS_URL"), attributes: jenkins},
+		// Cloud Build does not mark its steps, so its substitutions have to
+		// be passed to the step environment. Jenkins sets BUILD_ID as well,
+		// which is why it is checked first.
+		{name: CloudBuild, lookup: lookup, detected: allSet("BUILD_ID", "PROJECT_ID"), attributes: cloudBuild},
+	}
+}
+
+// Resolve returns the detectors selected by name. Auto selects the detector
+// of the CI system the build runs in, if any, and None selects no detector.
+func Resolve(names []string, lookup Lookup) ([]resource.Detector, error) {
+	all := Detectors(lookup)
+
+	var selected []resource.Detector
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", None:
+			continue
+		case Auto:
+			for _, detector := range all {
+				if detector.Detected() {
+					selected = append(selected, detector)
+					break
+				}
+			}
+			continue
+		}
+
+		found := false
+		for _, detector := range all {
+			if detector.name == name {
+				selected = append(selected, detector)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown resource detector %q, expected one of %s, %q or %q",
+				name, strings.Join(detectorNames(all), ", "), Auto, None)
+		}
+	}
+	return selected, nil
+}
+
+// detectorNames returns the quoted names of the detectors
+func detectorNames(detectors []*Detector) []string {
+	result := make([]string, 0, len(detectors))
+	for _, detector := range detectors {
+		result = append(result, strconv.Quote(detector.name))
+	}
+	return result
+}
+
+// isTrue detects a CI system by a variable set to "true"
+func isTrue(key string) func(Lookup) bool {
+	return func(env Lookup) bool {
+		return strings.EqualFold(env.get(key), "true")
+	}
+}
+
+// isSet detects a CI system by a variable being set
+func isSet(key string) func(Lookup) bool {
+	return allSet(key)
+}
+
+// allSet detects a CI system by several variables being set
+func allSet(keys ...string) func(Lookup) bool {
+	return func(env Lookup) bool {
+		for _, key := range keys {
+			if env.get(key) == "" {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// githubActions describes a GitHub Actions job
+func githubActions(env Lookup) []attribute.KeyValue {
+	var repository, runURL string
+	if server, repo := env.get("GITHUB_SERVER_URL"), env.get("GITHUB_REPOSITORY"); server != "" && repo != "" {
+		repository = server + "/" + repo
+		if runID := env.get("GITHUB_RUN_ID"); runID != "" {
+			runURL = repository + "/actions/runs/" + runID
+		}
+	}
+
+	// Pull requests run on a merge ref, the branch is the head ref
+	branch := env.get("GITHUB_HEAD_REF")
+	if branch == "" {
+		branch = env.get("GITHUB_REF_NAME")
+	}
+
+	return []attribute.KeyValue{
+		PipelineName.String(env.get("GITHUB_WORKFLOW")),
+		PipelineRunID.String(env.get("GITHUB_RUN_ID")),
+		PipelineRunURL.String(runURL),
+		RunAttempt.String(env.get("GITHUB_RUN_ATTEMPT")),
+		TaskName.String(env.get("GITHUB_JOB")),
+		WorkerName.String(env.get("RUNNER_NAME")),
+		RepositoryURL.String(repository),
+		Revision.String(env.get("GITHUB_SHA")),
+		Branch.String(branch),
+	}
+}
+
+// cloudBuild describes a Google Cloud Build build from its substitutions.
+// They name the repository rather than give its URL.
+func cloudBuild(env Lookup) []attribute.KeyValue {
+	repository := env.get("REPO_FULL_NAME")
+	if repository == "" {
+		repository = env.get("REPO_NAME")
+	}
+
+	return []attribute.KeyValue{
+		PipelineName.String(env.get("TRIGGER_NAME")),
+		PipelineRunID.String(env.get("BUILD_ID")),
+		RepositoryName.String(repository),
+		Revision.String(env.get("COMMIT_SHA")),
+		Branch.String(env.get("BRANCH_NAME")),
+	}
+}
+
+// gitlab describes a GitLab CI job
+func gitlab(env Lookup) []attribute.KeyValue {
+	name := env.get("CI_PIPELINE_NAME")
+	if name == "" {
+		name = env.get("CI_PROJECT_PATH")
+	}
+
+	return []attribute.KeyValue{
+		PipelineName.String(name),
+		PipelineRunID.String(env.get("CI_PIPELINE_ID")),
+		PipelineRunURL.String(env.get("CI_PIPELINE_URL")),
+		TaskName.String(env.get("CI_JOB_NAME")),
+		TaskRunID.String(env.get("CI_JOB_ID")),
+		WorkerName.String(env.get("CI_RUNNER_DESCRIPTION")),
+		RepositoryURL.String(env.get("CI_PROJECT_URL")),
+		Revision.String(env.get("CI_COMMIT_SHA")),
+		Branch.String(env.get("CI_COMMIT_REF_NAME")),
+	}
+}
+
+// circleCI describes a CircleCI job
+func circleCI(env Lookup) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		PipelineName.String(env.get("CIRCLE_PROJECT_REPONAME")),
+		PipelineRunID.String(env.get("CIRCLE_WORKFLOW_ID")),
+		PipelineRunURL.String(env.get("CIRCLE_BUILD_URL")),
+		TaskName.String(env.get("CIRCLE_JOB")),
+		TaskRunID.String(env.get("CIRCLE_BUILD_NUM")),
+		RepositoryURL.String(env.get("CIRCLE_REPOSITORY_URL")),
+		Revision.String(env.get("CIRCLE_SHA1")),
+		Branch.String(env.get("CIRCLE_BRANCH")),
+	}
+}
+
+// buildkite describes a Buildkite job
+func buildkite(env Lookup) []attribute.KeyValue {
+	// BUILDKITE_RETRY_COUNT counts the retries, the first attempt is 1
+	attempt := ""
+	if retries, err := strconv.Atoi(env.get("BUILDKITE_RETRY_COUNT")); err == nil {
+		attempt = strconv.Itoa(retries + 1)
+	}
+
+	return []attribute.KeyValue{
+		PipelineName.String(env.get("BUILDKITE_PIPELINE_SLUG")),
+		PipelineRunID.String(env.get("BUILDKITE_BUILD_ID")),
+		PipelineRunURL.String(env.get("BUILDKITE_BUILD_URL")),
+		RunAttempt.String(attempt),
+		TaskName.String(env.get("BUILDKITE_LABEL")),
+		TaskRunID.String(env.get("BUILDKITE_JOB_ID")),
+		WorkerName.String(env.get("BUILDKITE_AGENT_NAME")),
+		RepositoryURL.String(env.get("BUILDKITE_REPO")),
+		Revision.String(env.get("BUILDKITE_COMMIT")),
+		Branch.String(env.get("BUILDKITE_BRANCH")),
+	}
+}
+
+// jenkins describes a Jenkins build, with the variables of the git plugin
+func jenkins(env Lookup) []attribute.KeyValue {
+	branch := env.get("BRANCH_NAME")
+	if branch == "" {
+		branch = strings.TrimPrefix(env.get("GIT_BRANCH"), "origin/")
+	}
+
+	return []attribute.KeyValue{
+		PipelineName.String(env.get("JOB_NAME")),
+		PipelineRunID.String(env.get("BUILD_NUMBER")),
+		PipelineRunURL.String(env.get("BUILD_URL")),
+		TaskName.String(env.get("STAGE_NAME")),
+		WorkerName.String(env.get("NODE_NAME")),
+		RepositoryURL.String(env.get("GIT_URL")),
+		Revision.String(env.get("GIT_COMMIT")),
+		Branch.String(branch),
+	}
+}
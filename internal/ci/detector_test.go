@@ -0,0 +1,216 @@
+package ci
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func envLookup(vars map[string]string) Lookup {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// detect runs the detector with the given name and returns its attributes
+func detect(t *testing.T, name string, vars map[string]string) map[attribute.Key]string {
+	detectors, err := Resolve([]string{name}, envLookup(vars))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(detectors) != 1 {
+		t.Fatalf("Expected 1 detector, got %d", len(detectors))
+	}
+
+	res, err := detectors[0].Detect(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	attrs := map[attribute.Key]string{}
+	for _, attr := range res.Attributes() {
+		attrs[attr.Key] = attr.Value.AsString()
+	}
+	return attrs
+}
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		expected map[attribute.Key]string
+	}{
+		{
+			name: GitHubActions,
+			vars: map[string]string{
+				"GITHUB_ACTIONS":     "true",
+				"GITHUB_WORKFLOW":    "build",
+				"GITHUB_RUN_ID":      "42",
+				"GITHUB_RUN_ATTEMPT": "2",
+				"GITHUB_JOB":         "docker",
+				"GITHUB_SERVER_URL":  "https://github.com",
+				"GITHUB_REPOSITORY":  "acme/app",
+				"GITHUB_SHA":         "abc123",
+				"GITHUB_REF_NAME":    "123/merge",
+				"GITHUB_HEAD_REF":    "feature",
+				"RUNNER_NAME":        "runner-1",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:   "build",
+				PipelineRunID:  "42",
+				PipelineRunURL: "https://github.com/acme/app/actions/runs/42",
+				RunAttempt:     "2",
+				TaskName:       "docker",
+				WorkerName:     "runner-1",
+				RepositoryURL:  "https://github.com/acme/app",
+				Revision:       "abc123",
+				Branch:         "feature",
+			},
+		},
+		{
+			name: CloudBuild,
+			vars: map[string]string{
+				"BUILD_ID":     "b-1",
+				"PROJECT_ID":   "acme",
+				"TRIGGER_NAME": "deploy",
+				"REPO_NAME":    "app",
+				"COMMIT_SHA":   "abc123",
+				"BRANCH_NAME":  "main",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:   "deploy",
+				PipelineRunID:  "b-1",
+				RepositoryName: "app",
+				Revision:       "abc123",
+				Branch:         "main",
+			},
+		},
+		{
+			name: GitLab,
+			vars: map[string]string{
+				"GITLAB_CI":          "true",
+				"CI_PROJECT_PATH":    "acme/app",
+				"CI_PIPELINE_ID":     "7",
+				"CI_JOB_NAME":        "build",
+				"CI_JOB_ID":          "70",
+				"CI_PROJECT_URL":     "https://gitlab.com/acme/app",
+				"CI_COMMIT_SHA":      "abc123",
+				"CI_COMMIT_REF_NAME": "main",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:  "acme/app",
+				PipelineRunID: "7",
+				TaskName:      "build",
+				TaskRunID:     "70",
+				RepositoryURL: "https://gitlab.com/acme/app",
+				Revision:      "abc123",
+				Branch:        "main",
+			},
+		},
+		{
+			name: CircleCI,
+			vars: map[string]string{
+				"CIRCLECI":                "true",
+				"CIRCLE_PROJECT_REPONAME": "app",
+				"CIRCLE_WORKFLOW_ID":      "w-1",
+				"CIRCLE_BUILD_URL":        "https://circleci.com/gh/acme/app/9",
+				"CIRCLE_JOB":              "build",
+				"CIRCLE_BUILD_NUM":        "9",
+				"CIRCLE_REPOSITORY_URL":   "git@github.com:acme/app.git",
+				"CIRCLE_SHA1":             "abc123",
+				"CIRCLE_BRANCH":           "main",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:   "app",
+				PipelineRunID:  "w-1",
+				PipelineRunURL: "https://circleci.com/gh/acme/app/9",
+				TaskName:       "build",
+				TaskRunID:      "9",
+				RepositoryURL:  "git@github.com:acme/app.git",
+				Revision:       "abc123",
+				Branch:         "main",
+			},
+		},
+		{
+			name: Buildkite,
+			vars: map[string]string{
+				"BUILDKITE":               "true",
+				"BUILDKITE_PIPELINE_SLUG": "app",
+				"BUILDKITE_BUILD_ID":      "b-1",
+				"BUILDKITE_RETRY_COUNT":   "0",
+				"BUILDKITE_AGENT_NAME":    "agent-1",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:  "app",
+				PipelineRunID: "b-1",
+				RunAttempt:    "1",
+				WorkerName:    "agent-1",
+			},
+		},
+		{
+			name: Jenkins,
+			vars: map[string]string{
+				"JENKINS_URL":  "https://jenkins.example.com/",
+				"JOB_NAME":     "app/main",
+				"BUILD_NUMBER": "12",
+				"BUILD_ID":     "12",
+				"GIT_BRANCH":   "origin/main",
+				"NODE_NAME":    "agent-1",
+			},
+			expected: map[attribute.Key]string{
+				PipelineName:  "app/main",
+				PipelineRunID: "12",
+				WorkerName:    "agent-1",
+				Branch:        "main",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := detect(t, tt.name, tt.vars)
+			if len(attrs) != len(tt.expected) {
+				t.Errorf("Expected %d attributes, got %v", len(tt.expected), attrs)
+			}
+			for key, expected := range tt.expected {
+				if attrs[key] != expected {
+					t.Errorf("Expected %s to be '%s', got '%s'", key, expected, attrs[key])
+				}
+			}
+
+			// Auto detection picks the same CI system
+			if auto := detect(t, Auto, tt.vars); auto[PipelineRunID] != tt.expected[PipelineRunID] {
+				t.Errorf("Expected auto detection to find %s, got %v", tt.name, auto)
+			}
+		})
+	}
+}
+
+func TestDetector_NotDetected(t *testing.T) {
+	attrs := detect(t, CircleCI, map[string]string{"CIRCLE_JOB": "build"})
+	if len(attrs) != 0 {
+		t.Errorf("Expected no attributes outside of CircleCI, got %v", attrs)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	detectors, err := Resolve([]string{Auto}, envLookup(nil))
+	if err != nil || len(detectors) != 0 {
+		t.Errorf("Expected no detector outside of CI, got %d, err=%v", len(detectors), err)
+	}
+
+	detectors, err = Resolve([]string{None}, envLookup(map[string]string{"GITHUB_ACTIONS": "true"}))
+	if err != nil || len(detectors) != 0 {
+		t.Errorf("Expected none to select no detector, got %d, err=%v", len(detectors), err)
+	}
+
+	detectors, err = Resolve([]string{" GitHub ", "gitlab"}, envLookup(nil))
+	if err != nil || len(detectors) != 2 {
+		t.Errorf("Expected 2 detectors, got %d, err=%v", len(detectors), err)
+	}
+
+	if _, err := Resolve([]string{"travis"}, envLookup(nil)); err == nil {
+		t.Errorf("Expected an unknown detector error")
+	}
+}
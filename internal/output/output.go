@@ -0,0 +1,105 @@
+package output
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Var is a named value handed to the later steps of a pipeline
+type Var struct {
+	Name  string
+	Value string
+}
+
+// WriteTraceParent writes a traceparent header alone to a file, replacing
+// the file
+func WriteTraceParent(path, traceParent string) error {
+	if err := os.WriteFile(path, []byte(traceParent+"\n"), 0o644); err != nil {
+		return fmt.Errorf("writing traceparent file: %w", err)
+	}
+	return nil
+}
+
+// WriteShellExports writes the variables as "export NAME='value'" lines to
+// a file to be sourced by a shell, replacing the file
+func WriteShellExports(path string, vars []Var) error {
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "export %s=%s\n", v.Name, shellQuote(v.Value))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("writing exports file: %w", err)
+	}
+	return nil
+}
+
+// AppendGitHubFile appends the variables to a GitHub Actions file such as
+// $GITHUB_OUTPUT or $GITHUB_ENV. Values spanning several lines are written
+// with a random delimiter, as GitHub Actions requires.
+func AppendGitHubFile(path string, vars []Var) error {
+	var b strings.Builder
+	for _, v := range vars {
+		if !strings.ContainsAny(v.Value, "\r\n") {
+			fmt.Fprintf(&b, "%s=%s\n", v.Name, v.Value)
+			continue
+		}
+		delimiter, err := randomDelimiter()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", v.Name, delimiter, v.Value, delimiter)
+	}
+	return appendFile(path, b.String())
+}
+
+// shellQuote quotes a value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// randomDelimiter returns a delimiter that cannot occur in a value by chance
+func randomDelimiter() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating delimiter: %w", err)
+	}
+	return "EOF_" + hex.EncodeToString(raw), nil
+}
+
+// AppendStepSummary appends markdown to the GitHub Actions job summary file,
+// $GITHUB_STEP_SUMMARY
+func AppendStepSummary(path, markdown string) error {
+	return appendFile(path, markdown+"\n")
+}
+
+// appendFile appends content to a file, creating it if needed
+func appendFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// GitHubNotice returns a GitHub Actions workflow command that shows message
+// as a notice annotation of the run when printed to stdout
+func GitHubNotice(title, message string) string {
+	return fmt.Sprintf("::notice title=%s::%s", escapeProperty(title), escapeData(message))
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+// escapeProperty escapes a property of a workflow command
+func escapeProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readFile returns the content of a file written by a test
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestWriteTraceParent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traceparent")
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if err := WriteTraceParent(path, traceParent); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if content := readFile(t, path); content != traceParent+"\n" {
+		t.Errorf("Expected the traceparent alone, got '%s'", content)
+	}
+}
+
+func TestWriteShellExports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exports.sh")
+	err := WriteShellExports(path, []Var{
+		{Name: "TRACEPARENT", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{Name: "TRACESTATE", Value: "vendor='abc'"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "export TRACEPARENT='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'\n" +
+		"export TRACESTATE='vendor='\\''abc'\\'''\n"
+	if content := readFile(t, path); content != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, content)
+	}
+}
+
+func TestAppendGitHubFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "github_output")
+	if err := os.WriteFile(path, []byte("existing=1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	err := AppendGitHubFile(path, []Var{
+		{Name: "trace-id", Value: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{Name: "summary", Value: "line 1\nline 2"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(readFile(t, path), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 lines, got %q", lines)
+	}
+	if lines[0] != "existing=1" || lines[1] != "trace-id=4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the variable to be appended, got %q", lines[:2])
+	}
+	delimiter := strings.TrimPrefix(lines[2], "summary<<")
+	if delimiter == lines[2] || lines[3] != "line 1" || lines[4] != "line 2" || lines[5] != delimiter {
+		t.Errorf("Expected a multiline value with a delimiter, got %q", lines[2:])
+	}
+}
@@ -0,0 +1,48 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TraceURLData holds the fields available to trace URL templates
+type TraceURLData struct {
+	TraceID string
+	SpanID  string
+	Service string
+	Start   time.Time
+	End     time.Time
+}
+
+// TraceURLTemplate renders links to a trace in a tracing UI, such as
+// "https://tempo.example/trace/{{.TraceID}}"
+type TraceURLTemplate struct {
+	template *template.Template
+}
+
+// ParseTraceURLTemplate parses a trace URL template. Templates referring to
+// unknown fields are rejected here rather than when rendering, so mistakes
+// show up before the build is exported.
+func ParseTraceURLTemplate(text string) (*TraceURLTemplate, error) {
+	tmpl, err := template.New("trace-url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing trace URL template: %w", err)
+	}
+
+	t := &TraceURLTemplate{template: tmpl}
+	if _, err := t.Render(TraceURLData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render renders the URL of a trace
+func (t *TraceURLTemplate) Render(data TraceURLData) (string, error) {
+	var b strings.Builder
+	if err := t.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering trace URL: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,44 @@
+package output
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTraceURLTemplate(t *testing.T) {
+	tmpl, err := ParseTraceURLTemplate("https://tempo.example/trace/{{.TraceID}}?from={{.Start.UnixMilli}}&to={{.End.UnixMilli}}&service={{.Service | urlquery}}")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	url, err := tmpl.Render(TraceURLData{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		Service: "docker build",
+		Start:   time.UnixMilli(1000),
+		End:     time.UnixMilli(5000),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "https://tempo.example/trace/4bf92f3577b34da6a3ce929d0e0e4736?from=1000&to=5000&service=docker+build"
+	if url != expected {
+		t.Errorf("Expected %s, got %s", expected, url)
+	}
+}
+
+func TestParseTraceURLTemplate_Errors(t *testing.T) {
+	for _, text := range []string{"https://tempo.example/trace/{{.TraceID", "https://tempo.example/trace/{{.Trace}}"} {
+		if _, err := ParseTraceURLTemplate(text); err == nil {
+			t.Errorf("Expected an error for '%s'", text)
+		}
+	}
+}
+
+func TestGitHubNotice(t *testing.T) {
+	notice := GitHubNotice("Build trace: docker", "https://tempo.example/trace/abc?q=100%\n")
+	expected := "::notice title=Build trace%3A docker::https://tempo.example/trace/abc?q=100%25%0A"
+	if notice != expected {
+		t.Errorf("Expected %s, got %s", expected, notice)
+	}
+}
@@ -0,0 +1,23 @@
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseAttribute parses a single "key=value" attribute. The value is taken
+// as is, so it may contain spaces, commas and further "=" signs.
+func ParseAttribute(attr string) (string, string, error) {
+	key, value, found := strings.Cut(attr, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", fmt.Errorf("invalid attribute %q, expected key=value", attr)
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// ReadAttributesFile reads attributes from a file with one "key=value"
+// attribute per line. Empty lines and lines starting with "#" are skipped.
+func ReadAttributesFile(path string) (map[string]string, error) {
+	return readKeyValuesFile(path, "attributes", ParseAttribute)
+}
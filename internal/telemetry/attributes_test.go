@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAttribute(t *testing.T) {
+	key, value, err := ParseAttribute(" image = registry.example.com/app:v1=latest ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if key != "image" || value != "registry.example.com/app:v1=latest" {
+		t.Errorf("Expected image=registry.example.com/app:v1=latest, got %s=%s", key, value)
+	}
+
+	for _, attr := range []string{"", "team", "=platform"} {
+		if _, _, err := ParseAttribute(attr); err == nil {
+			t.Errorf("Expected an error for '%s'", attr)
+		}
+	}
+}
+
+func TestReadAttributesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attributes")
+	content := "# build metadata\n\nteam=platform\ngit.ref = refs/heads/main\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write attributes file: %v", err)
+	}
+
+	attrs, err := ReadAttributesFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if attrs["team"] != "platform" || attrs["git.ref"] != "refs/heads/main" {
+		t.Errorf("Expected attributes from the file, got %v", attrs)
+	}
+
+	if err := os.WriteFile(path, []byte("team=platform\ninvalid\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write attributes file: %v", err)
+	}
+	if _, err := ReadAttributesFile(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestExportBuildTraces_SpanAttributes(t *testing.T) {
+	tracer, recorder := newRecordingTracer(t)
+	tracer.config.SpanAttributes = map[string]string{"team": "platform", "image": "app"}
+
+	if _, err := tracer.ExportBuildTraces(context.Background(), oneStepGraph()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, span := range recorder.Ended() {
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes() {
+			attrs[string(attr.Key)] = attr.Value.AsString()
+		}
+
+		if span.Name() == "docker-build" {
+			if attrs["team"] != "platform" || attrs["image"] != "app" {
+				t.Errorf("Expected span attributes on the build span, got %v", attrs)
+			}
+		} else if _, ok := attrs["team"]; ok {
+			t.Errorf("Expected span attributes only on the build span, got them on %s", span.Name())
+		}
+	}
+}
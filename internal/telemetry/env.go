@@ -0,0 +1,163 @@
+package telemetry
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Standard OpenTelemetry environment variables
+const (
+	EnvEndpoint           = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvTracesEndpoint     = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	EnvProtocol           = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	EnvTracesProtocol     = "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
+	EnvHeaders            = "OTEL_EXPORTER_OTLP_HEADERS"
+	EnvTracesHeaders      = "OTEL_EXPORTER_OTLP_TRACES_HEADERS"
+	EnvCertificate        = "OTEL_EXPORTER_OTLP_CERTIFICATE"
+	EnvClientCertificate  = "OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE"
+	EnvClientKey          = "OTEL_EXPORTER_OTLP_CLIENT_KEY"
+	EnvServiceName        = "OTEL_SERVICE_NAME"
+	EnvResourceAttributes = "OTEL_RESOURCE_ATTRIBUTES"
+	EnvTracesSampler      = "OTEL_TRACES_SAMPLER"
+	EnvTracesSamplerArg   = "OTEL_TRACES_SAMPLER_ARG"
+)
+
+// ApplyEnv fills the settings that are not set yet from the standard
+// OpenTelemetry environment variables, looked up with lookup, e.g.
+// os.LookupEnv. See ApplyEnvWithLogger.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) {
+	// Create a noop logger if none is provided
+	noop, _ := logger.New(logger.DefaultConfig())
+	c.ApplyEnvWithLogger(lookup, noop)
+}
+
+// ApplyEnvWithLogger fills the settings that are not set yet from the
+// standard OpenTelemetry environment variables, looked up with lookup, e.g.
+// os.LookupEnv. Settings already in the config take precedence, so callers
+// set what was given explicitly first and apply their defaults afterwards.
+// Headers and resource attributes are merged key by key. As the
+// specification requires, invalid values in the environment are logged and
+// ignored rather than failing, falling back to the defaults.
+func (c *Config) ApplyEnvWithLogger(lookup func(string) (string, bool), log logger.Logger) {
+	env := func(keys ...string) (string, string) {
+		for _, key := range keys {
+			if value, ok := lookup(key); ok && strings.TrimSpace(value) != "" {
+				return key, strings.TrimSpace(value)
+			}
+		}
+		return "", ""
+	}
+	ignore := func(key string, err error) {
+		log.Warn("Ignoring invalid environment variable", zap.String("variable", key), zap.Error(err))
+	}
+
+	if c.Protocol == "" {
+		if key, protocol := env(EnvTracesProtocol, EnvProtocol); protocol != "" {
+			if err := validateProtocol(protocol); err != nil {
+				ignore(key, err)
+			} else {
+				c.Protocol = protocol
+			}
+		}
+	}
+
+	if c.OTLPEndpoint == "" {
+		if _, endpoint := env(EnvTracesEndpoint); endpoint != "" {
+			c.OTLPEndpoint = endpoint
+		} else if _, endpoint := env(EnvEndpoint); endpoint != "" {
+			c.OTLPEndpoint = baseEndpoint(endpoint, c.protocol(), c.URLPath)
+		}
+	}
+
+	headers := make(map[string]string)
+	for _, key := range []string{EnvHeaders, EnvTracesHeaders} {
+		_, value := env(key)
+		parsed, err := ParseHeaders(value)
+		if err != nil {
+			ignore(key, err)
+			continue
+		}
+		for name, header := range parsed {
+			headers[name] = header
+		}
+	}
+	c.Headers = merge(headers, c.Headers)
+
+	if c.CACertFile == "" {
+		_, c.CACertFile = env(EnvCertificate)
+	}
+	if c.ClientCertFile == "" {
+		_, c.ClientCertFile = env(EnvClientCertificate)
+	}
+	if c.ClientKeyFile == "" {
+		_, c.ClientKeyFile = env(EnvClientKey)
+	}
+
+	_, value := env(EnvResourceAttributes)
+	attributes, err := parseKeyValues(value)
+	if err != nil {
+		ignore(EnvResourceAttributes, err)
+		attributes = nil
+	}
+	c.ResourceAttributes = merge(attributes, c.ResourceAttributes)
+
+	// OTEL_SERVICE_NAME takes precedence over a service.name resource attribute
+	if c.ServiceName == "" {
+		_, c.ServiceName = env(EnvServiceName)
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = attributes["service.name"]
+	}
+
+	// The sampler argument applies to a sampler given explicitly as well
+	if c.Sampler == "" {
+		if _, sampler := env(EnvTracesSampler); sampler != "" {
+			if _, err := newSampler(sampler, ""); err != nil {
+				ignore(EnvTracesSampler, err)
+			} else {
+				c.Sampler = sampler
+			}
+		}
+	}
+	if c.SamplerArg == "" {
+		if _, arg := env(EnvTracesSamplerArg); arg != "" {
+			if _, err := newSampler(SamplerTraceIDRatio, arg); err != nil {
+				ignore(EnvTracesSamplerArg, err)
+			} else {
+				c.SamplerArg = arg
+			}
+		}
+	}
+}
+
+// baseEndpoint returns the endpoint to send traces to from the base URL in
+// OTEL_EXPORTER_OTLP_ENDPOINT. Over HTTP the traces path is appended to the
+// path of the base URL, as the specification requires.
+func baseEndpoint(endpoint, protocol, urlPath string) string {
+	if protocol != ProtocolHTTPProtobuf || !hasScheme(endpoint) {
+		return endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	if urlPath == "" {
+		urlPath = DefaultURLPath
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + urlPath
+	return u.String()
+}
+
+// merge returns the entries of base overridden by those of override
+func merge(base, override map[string]string) map[string]string {
+	if len(base) == 0 {
+		return override
+	}
+	for key, value := range override {
+		base[key] = value
+	}
+	return base
+}
@@ -0,0 +1,152 @@
+package telemetry
+
+import (
+	"testing"
+)
+
+// envLookup returns a lookup function over the given variables
+func envLookup(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	config := Config{
+		ServiceName: "from-flag",
+		Headers:     map[string]string{"x-scope": "flag"},
+	}
+
+	config.ApplyEnv(envLookup(map[string]string{
+		EnvEndpoint:           "collector:4317",
+		EnvHeaders:            "Authorization=Bearer%20abc,x-scope=env",
+		EnvServiceName:        "from-env",
+		EnvResourceAttributes: "team=platform,deployment.environment=ci",
+		EnvTracesSampler:      SamplerParentBasedTraceIDRatio,
+		EnvTracesSamplerArg:   "0.25",
+	}))
+
+	if config.OTLPEndpoint != "collector:4317" {
+		t.Errorf("Expected endpoint from the environment, got '%s'", config.OTLPEndpoint)
+	}
+	if config.ServiceName != "from-flag" {
+		t.Errorf("Expected the explicit service name to win, got '%s'", config.ServiceName)
+	}
+	if config.Headers["Authorization"] != "Bearer abc" || config.Headers["x-scope"] != "flag" {
+		t.Errorf("Expected headers merged with explicit ones winning, got %v", config.Headers)
+	}
+	if config.ResourceAttributes["team"] != "platform" {
+		t.Errorf("Expected resource attributes from the environment, got %v", config.ResourceAttributes)
+	}
+	if config.Sampler != SamplerParentBasedTraceIDRatio || config.SamplerArg != "0.25" {
+		t.Errorf("Expected sampler from the environment, got '%s' '%s'", config.Sampler, config.SamplerArg)
+	}
+}
+
+func TestConfig_ApplyEnvDefaults(t *testing.T) {
+	var config Config
+	config.ApplyEnv(envLookup(nil))
+
+	if config.OTLPEndpoint != "" || config.ServiceName != "" || config.Sampler != "" {
+		t.Errorf("Expected nothing to be set without environment variables, got %+v", config)
+	}
+	if config.endpoint() != DefaultGRPCEndpoint {
+		t.Errorf("Expected default endpoint, got '%s'", config.endpoint())
+	}
+}
+
+func TestConfig_ApplyEnvEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		expected string
+	}{
+		{
+			name: "base URL over HTTP gets the traces path",
+			vars: map[string]string{
+				EnvProtocol: ProtocolHTTPProtobuf,
+				EnvEndpoint: "https://otlp.example.com/prefix/",
+			},
+			expected: "https://otlp.example.com/prefix/v1/traces",
+		},
+		{
+			name: "traces endpoint is used as is",
+			vars: map[string]string{
+				EnvTracesProtocol: ProtocolHTTPProtobuf,
+				EnvEndpoint:       "https://ignored.example.com",
+				EnvTracesEndpoint: "https://otlp.example.com/ingest",
+			},
+			expected: "https://otlp.example.com/ingest",
+		},
+		{
+			name:     "base URL over gRPC",
+			vars:     map[string]string{EnvEndpoint: "https://otlp.example.com:4317"},
+			expected: "https://otlp.example.com:4317",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.ApplyEnv(envLookup(tt.vars))
+			if config.OTLPEndpoint != tt.expected {
+				t.Errorf("Expected endpoint '%s', got '%s'", tt.expected, config.OTLPEndpoint)
+			}
+		})
+	}
+}
+
+func TestConfig_ApplyEnvServiceNameFromResource(t *testing.T) {
+	var config Config
+	config.ApplyEnv(envLookup(map[string]string{
+		EnvResourceAttributes: "service.name=from-resource",
+	}))
+	if config.ServiceName != "from-resource" {
+		t.Errorf("Expected service name from the resource attributes, got '%s'", config.ServiceName)
+	}
+}
+
+func TestConfig_ApplyEnvSamplerArg(t *testing.T) {
+	config := Config{Sampler: SamplerTraceIDRatio}
+	config.ApplyEnv(envLookup(map[string]string{
+		EnvTracesSampler:    SamplerAlwaysOff,
+		EnvTracesSamplerArg: "0.1",
+	}))
+
+	if config.Sampler != SamplerTraceIDRatio || config.SamplerArg != "0.1" {
+		t.Errorf("Expected the explicit sampler with the ratio from the environment, got '%s' '%s'", config.Sampler, config.SamplerArg)
+	}
+}
+
+func TestConfig_ApplyEnvIgnoresInvalidValues(t *testing.T) {
+	var config Config
+	config.ApplyEnv(envLookup(map[string]string{
+		EnvProtocol:           "http/json",
+		EnvEndpoint:           "http://collector:4318",
+		EnvHeaders:            "invalid",
+		EnvTracesHeaders:      "x-scope=traces",
+		EnvResourceAttributes: "invalid",
+		EnvTracesSampler:      "sometimes",
+		EnvTracesSamplerArg:   "2",
+	}))
+
+	if config.Protocol != "" || config.Sampler != "" || config.SamplerArg != "" {
+		t.Errorf("Expected invalid protocol and sampler to fall back to the defaults, got '%s' '%s' '%s'",
+			config.Protocol, config.Sampler, config.SamplerArg)
+	}
+	if config.OTLPEndpoint != "http://collector:4318" {
+		t.Errorf("Expected the endpoint of the default protocol, got '%s'", config.OTLPEndpoint)
+	}
+	if len(config.Headers) != 1 || config.Headers["x-scope"] != "traces" {
+		t.Errorf("Expected only the valid headers, got %v", config.Headers)
+	}
+	if len(config.ResourceAttributes) != 0 {
+		t.Errorf("Expected no resource attributes, got %v", config.ResourceAttributes)
+	}
+
+	// Invalid values given explicitly are not ignored
+	if _, err := newClient(Config{Protocol: "http/json"}); err == nil {
+		t.Errorf("Expected an error for an unsupported explicit protocol")
+	}
+}
@@ -0,0 +1,250 @@
+package telemetry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"google.golang.org/grpc/credentials"
+)
+
+// OTLP protocols the traces can be exported with
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+// Default endpoints and URL path of the OTLP protocols
+const (
+	DefaultGRPCEndpoint = "localhost:4317"
+	DefaultHTTPEndpoint = "localhost:4318"
+	DefaultURLPath      = "/v1/traces"
+)
+
+// newClient creates the OTLP trace client for the configured protocol.
+// The endpoint is either a host and port, or a URL whose scheme decides
+// whether the connection is secure. Secure connections verify the collector
+// against the system roots unless a CA certificate is configured.
+func newClient(config Config) (otlptrace.Client, error) {
+	conn, err := config.connection(DefaultURLPath)
+	if err != nil {
+		return nil, err
+	}
+	if config.protocol() == ProtocolHTTPProtobuf {
+		return otlptracehttp.NewClient(traceHTTPOptions.build(conn)...), nil
+	}
+	return otlptracegrpc.NewClient(traceGRPCOptions.build(conn)...), nil
+}
+
+// validateProtocol returns an error unless protocol is a supported OTLP
+// protocol
+func validateProtocol(protocol string) error {
+	switch protocol {
+	case ProtocolGRPC, ProtocolHTTPProtobuf:
+		return nil
+	default:
+		return fmt.Errorf("unsupported OTLP protocol %q, expected %q or %q",
+			protocol, ProtocolGRPC, ProtocolHTTPProtobuf)
+	}
+}
+
+// connection describes how the exporter of a signal connects to the
+// collector, the same way for traces, metrics and logs
+type connection struct {
+	endpoint  string
+	urlPath   string
+	insecure  bool
+	tlsConfig *tls.Config
+	headers   map[string]string
+}
+
+// connection returns the connection of the exporter of the signal sent to
+// signalPath over HTTP
+func (c Config) connection(signalPath string) (connection, error) {
+	if err := validateProtocol(c.protocol()); err != nil {
+		return connection{}, err
+	}
+	insecure, tlsConfig, err := c.transport()
+	if err != nil {
+		return connection{}, err
+	}
+
+	conn := connection{
+		endpoint:  c.endpoint(),
+		insecure:  insecure,
+		tlsConfig: tlsConfig,
+		headers:   c.Headers,
+	}
+	if c.protocol() != ProtocolHTTPProtobuf {
+		return conn, nil
+	}
+
+	conn.urlPath = c.urlPath(signalPath)
+	if hasScheme(conn.endpoint) {
+		u, err := url.Parse(conn.endpoint)
+		if err != nil {
+			return connection{}, fmt.Errorf("parsing OTLP endpoint: %w", err)
+		}
+		u.Path = conn.urlPath
+		conn.endpoint = u.String()
+	}
+	return conn, nil
+}
+
+// otlpOptions builds the options of an OTLP exporter from a connection. The
+// exporter packages of each signal and protocol have option types of their
+// own, but the same constructors.
+type otlpOptions[O any] struct {
+	endpoint    func(string) O
+	endpointURL func(string) O
+	urlPath     func(string) O
+	insecure    func() O
+	tls         func(*tls.Config) O
+	headers     func(map[string]string) O
+}
+
+// build returns the options that connect the exporter as conn describes
+func (o otlpOptions[O]) build(conn connection) []O {
+	var opts []O
+	if hasScheme(conn.endpoint) {
+		opts = append(opts, o.endpointURL(conn.endpoint))
+	} else {
+		opts = append(opts, o.endpoint(conn.endpoint))
+		if o.urlPath != nil {
+			opts = append(opts, o.urlPath(conn.urlPath))
+		}
+	}
+	if conn.insecure {
+		opts = append(opts, o.insecure())
+	} else {
+		opts = append(opts, o.tls(conn.tlsConfig))
+	}
+	if len(conn.headers) > 0 {
+		opts = append(opts, o.headers(conn.headers))
+	}
+	return opts
+}
+
+var traceGRPCOptions = otlpOptions[otlptracegrpc.Option]{
+	endpoint:    otlptracegrpc.WithEndpoint,
+	endpointURL: otlptracegrpc.WithEndpointURL,
+	insecure:    otlptracegrpc.WithInsecure,
+	tls: func(config *tls.Config) otlptracegrpc.Option {
+		return otlptracegrpc.WithTLSCredentials(credentials.NewTLS(config))
+	},
+	headers: otlptracegrpc.WithHeaders,
+}
+
+var traceHTTPOptions = otlpOptions[otlptracehttp.Option]{
+	endpoint:    otlptracehttp.WithEndpoint,
+	endpointURL: otlptracehttp.WithEndpointURL,
+	urlPath:     otlptracehttp.WithURLPath,
+	insecure:    otlptracehttp.WithInsecure,
+	tls:         otlptracehttp.WithTLSClientConfig,
+	headers:     otlptracehttp.WithHeaders,
+}
+
+// tracesURLPath returns the path traces are sent to over HTTP: the path of
+// the endpoint URL if it has one, or the configured path
+func (c Config) tracesURLPath() string {
+	if endpoint := c.endpoint(); hasScheme(endpoint) {
+		if u, err := url.Parse(endpoint); err == nil && u.Path != "" && u.Path != "/" {
+			return u.Path
+		}
+	}
+	if c.URLPath != "" {
+		return c.URLPath
+	}
+	return DefaultURLPath
+}
+
+// urlPath returns the path a signal is sent to over HTTP. The path of the
+// other signals is derived from the traces path, whose /v1/traces suffix is
+// replaced by the path of the signal, so that a prefix of the collector is
+// kept for all of them.
+func (c Config) urlPath(signalPath string) string {
+	tracesPath := c.tracesURLPath()
+	if signalPath == DefaultURLPath {
+		return tracesPath
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(tracesPath, "/"), DefaultURLPath)
+	return strings.TrimSuffix(base, "/") + signalPath
+}
+
+// transport returns whether the exporter connects in plain text, and the TLS
+// configuration to use otherwise. For an endpoint URL the scheme decides,
+// otherwise the connection is in plain text unless TLS is set or TLS
+// certificates are configured.
+func (c Config) transport() (bool, *tls.Config, error) {
+	endpoint := c.endpoint()
+	hasCertificates := c.CACertFile != "" || c.ClientCertFile != "" || c.ClientKeyFile != ""
+	insecure := !c.TLS && !hasCertificates
+	if hasScheme(endpoint) {
+		insecure = !strings.HasPrefix(strings.ToLower(endpoint), "https://")
+	}
+
+	if insecure {
+		if hasCertificates {
+			return false, nil, fmt.Errorf("TLS certificates are configured but the connection to %s is insecure", endpoint)
+		}
+		return true, nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CACertFile != "" {
+		pem, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return false, nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, nil, fmt.Errorf("no certificates found in %s", c.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		if c.ClientCertFile == "" || c.ClientKeyFile == "" {
+			return false, nil, errors.New("both a client certificate and a client key are required for mTLS")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return false, nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return false, tlsConfig, nil
+}
+
+// protocol returns the configured protocol, gRPC by default
+func (c Config) protocol() string {
+	if c.Protocol == "" {
+		return ProtocolGRPC
+	}
+	return c.Protocol
+}
+
+// endpoint returns the configured endpoint, or the default one of the protocol
+func (c Config) endpoint() string {
+	if c.OTLPEndpoint != "" {
+		return c.OTLPEndpoint
+	}
+	if c.protocol() == ProtocolHTTPProtobuf {
+		return DefaultHTTPEndpoint
+	}
+	return DefaultGRPCEndpoint
+}
+
+// hasScheme reports whether the endpoint is a URL rather than a host and port
+func hasScheme(endpoint string) bool {
+	return strings.Contains(endpoint, "://")
+}
@@ -0,0 +1,396 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpCollector is a stand-in OTLP/HTTP collector recording the requests
+// it receives
+type httpCollector struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	requests []*collectortrace.ExportTraceServiceRequest
+}
+
+// newHTTPCollector starts a collector, serving TLS with the given
+// configuration if there is one
+func newHTTPCollector(t *testing.T, tlsConfig *tls.Config) *httpCollector {
+	c := &httpCollector{}
+	c.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		c.paths = append(c.paths, r.URL.Path)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.requests = append(c.requests, request)
+		c.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		response, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		_, _ = w.Write(response)
+	}))
+	if tlsConfig != nil {
+		c.server.TLS = tlsConfig
+		c.server.StartTLS()
+	} else {
+		c.server.Start()
+	}
+	t.Cleanup(c.server.Close)
+
+	return c
+}
+
+// spanNames returns the names of all spans received so far
+func (c *httpCollector) spanNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for _, request := range c.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// oneStepGraph returns the graph of a build with a single step
+func oneStepGraph() *buildx.Graph {
+	base := time.Unix(1000, 0)
+	return buildx.NewGraph([]buildx.BuildStep{
+		{Digest: "sha256:run", Name: "[stage-0 1/1] RUN make", Started: base, Completed: base.Add(time.Second)},
+	})
+}
+
+// exportTo exports a one step build with the given config and returns the
+// tracer after shutting it down, along with the shutdown error
+func exportTo(t *testing.T, config Config) (*Tracer, error) {
+	ctx := context.Background()
+	tracer, err := NewTracer(ctx, config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := tracer.ExportBuildTraces(ctx, oneStepGraph()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return tracer, tracer.Shutdown(ctx)
+}
+
+// exportToCollector exports a one step build with the given config and
+// flushes it
+func exportToCollector(t *testing.T, config Config) {
+	if _, err := exportTo(t, config); err != nil {
+		t.Fatalf("Expected spans to be flushed, got %v", err)
+	}
+}
+
+func TestNewTracer_HTTPProtobuf(t *testing.T) {
+	collector := newHTTPCollector(t, nil)
+
+	exportToCollector(t, Config{
+		OTLPEndpoint: strings.TrimPrefix(collector.server.URL, "http://"),
+		Protocol:     ProtocolHTTPProtobuf,
+		ServiceName:  "test-service",
+	})
+
+	if len(collector.paths) == 0 || collector.paths[0] != DefaultURLPath {
+		t.Errorf("Expected traces to be sent to %s, got %v", DefaultURLPath, collector.paths)
+	}
+
+	names := strings.Join(collector.spanNames(), ",")
+	for _, name := range []string{"docker-build", "stage-0", "RUN make"} {
+		if !strings.Contains(names, name) {
+			t.Errorf("Expected a %s span, got %s", name, names)
+		}
+	}
+}
+
+func TestNewTracer_HTTPProtobufPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint func(server string) string
+		urlPath  string
+		expected string
+	}{
+		{
+			name:     "host and custom path",
+			endpoint: func(server string) string { return strings.TrimPrefix(server, "http://") },
+			urlPath:  "/otlp/v1/traces",
+			expected: "/otlp/v1/traces",
+		},
+		{
+			name:     "URL without path",
+			endpoint: func(server string) string { return server },
+			expected: DefaultURLPath,
+		},
+		{
+			name:     "URL with path",
+			endpoint: func(server string) string { return server + "/ingest/traces" },
+			urlPath:  "/ignored",
+			expected: "/ingest/traces",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := newHTTPCollector(t, nil)
+
+			exportToCollector(t, Config{
+				OTLPEndpoint: tt.endpoint(collector.server.URL),
+				Protocol:     ProtocolHTTPProtobuf,
+				URLPath:      tt.urlPath,
+				ServiceName:  "test-service",
+			})
+
+			if len(collector.paths) == 0 || collector.paths[0] != tt.expected {
+				t.Errorf("Expected traces to be sent to %s, got %v", tt.expected, collector.paths)
+			}
+		})
+	}
+}
+
+func TestConfig_URLPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected map[string]string
+	}{
+		{
+			name:   "default",
+			config: Config{OTLPEndpoint: "collector:4318"},
+			expected: map[string]string{
+				DefaultURLPath:        "/v1/traces",
+				DefaultMetricsURLPath: "/v1/metrics",
+				DefaultLogsURLPath:    "/v1/logs",
+			},
+		},
+		{
+			name:   "host and prefixed path",
+			config: Config{OTLPEndpoint: "collector:4318", URLPath: "/otlp/v1/traces"},
+			expected: map[string]string{
+				DefaultURLPath:        "/otlp/v1/traces",
+				DefaultMetricsURLPath: "/otlp/v1/metrics",
+				DefaultLogsURLPath:    "/otlp/v1/logs",
+			},
+		},
+		{
+			name:   "URL with prefixed path",
+			config: Config{OTLPEndpoint: "http://collector:4318/otlp/v1/traces", URLPath: "/ignored"},
+			expected: map[string]string{
+				DefaultURLPath:        "/otlp/v1/traces",
+				DefaultMetricsURLPath: "/otlp/v1/metrics",
+				DefaultLogsURLPath:    "/otlp/v1/logs",
+			},
+		},
+		{
+			name:   "URL without path",
+			config: Config{OTLPEndpoint: "http://collector:4318", URLPath: "/otlp/v1/traces"},
+			expected: map[string]string{
+				DefaultURLPath:        "/otlp/v1/traces",
+				DefaultMetricsURLPath: "/otlp/v1/metrics",
+				DefaultLogsURLPath:    "/otlp/v1/logs",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Protocol = ProtocolHTTPProtobuf
+			for signalPath, expected := range tt.expected {
+				conn, err := tt.config.connection(signalPath)
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if conn.urlPath != expected {
+					t.Errorf("Expected %s to be sent to %s, got %s", signalPath, expected, conn.urlPath)
+				}
+				if hasScheme(conn.endpoint) && !strings.HasSuffix(conn.endpoint, expected) {
+					t.Errorf("Expected the endpoint URL to end with %s, got %s", expected, conn.endpoint)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTracer_UnknownProtocol(t *testing.T) {
+	_, err := NewTracer(context.Background(), Config{Protocol: "http/json"})
+	if err == nil || !strings.Contains(err.Error(), "http/json") {
+		t.Errorf("Expected an unsupported protocol error, got %v", err)
+	}
+}
+
+func TestConfig_Endpoint(t *testing.T) {
+	if endpoint := (Config{}).endpoint(); endpoint != DefaultGRPCEndpoint {
+		t.Errorf("Expected default gRPC endpoint, got '%s'", endpoint)
+	}
+	if endpoint := (Config{Protocol: ProtocolHTTPProtobuf}).endpoint(); endpoint != DefaultHTTPEndpoint {
+		t.Errorf("Expected default HTTP endpoint, got '%s'", endpoint)
+	}
+	if endpoint := (Config{OTLPEndpoint: "collector:4317"}).endpoint(); endpoint != "collector:4317" {
+		t.Errorf("Expected configured endpoint, got '%s'", endpoint)
+	}
+}
+
+// writePEM writes a PEM block to a file in the test directory
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestNewTracer_TLSWithHeaders(t *testing.T) {
+	collector := newHTTPCollector(t, &tls.Config{})
+	caCert := writePEM(t, "ca.pem", "CERTIFICATE", collector.server.Certificate().Raw)
+
+	exportToCollector(t, Config{
+		OTLPEndpoint: strings.TrimPrefix(collector.server.URL, "https://"),
+		Protocol:     ProtocolHTTPProtobuf,
+		CACertFile:   caCert,
+		Headers:      map[string]string{"Authorization": "Bearer secret"},
+		ServiceName:  "test-service",
+	})
+
+	if len(collector.headers) == 0 {
+		t.Fatalf("Expected traces to be sent over TLS")
+	}
+	if auth := collector.headers[0].Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Expected Authorization header to be sent, got '%s'", auth)
+	}
+}
+
+func TestNewTracer_MTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	clientCert, _ := x509.ParseCertificate(der)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	collector := newHTTPCollector(t, &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	})
+
+	exportToCollector(t, Config{
+		OTLPEndpoint:   collector.server.URL,
+		Protocol:       ProtocolHTTPProtobuf,
+		CACertFile:     writePEM(t, "ca.pem", "CERTIFICATE", collector.server.Certificate().Raw),
+		ClientCertFile: writePEM(t, "client.pem", "CERTIFICATE", der),
+		ClientKeyFile:  writePEM(t, "client-key.pem", "PRIVATE KEY", keyDER),
+		ServiceName:    "test-service",
+	})
+
+	if len(collector.requests) == 0 {
+		t.Errorf("Expected traces to be sent with the client certificate")
+	}
+}
+
+func TestConfig_TransportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "certificates with an http URL",
+			config: Config{OTLPEndpoint: "http://collector:4318", CACertFile: "ca.pem"},
+		},
+		{
+			name:   "client certificate without key",
+			config: Config{ClientCertFile: "client.pem"},
+		},
+		{
+			name:   "missing CA certificate",
+			config: Config{CACertFile: filepath.Join(t.TempDir(), "missing.pem")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.config.transport(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+
+	insecure, _, err := Config{OTLPEndpoint: "https://collector:4318"}.transport()
+	if err != nil || insecure {
+		t.Errorf("Expected an https URL to be secure, got insecure=%v, err=%v", insecure, err)
+	}
+}
+
+func TestConfig_TransportDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		insecure bool
+	}{
+		{name: "zero config", config: Config{}, insecure: true},
+		{name: "TLS", config: Config{TLS: true}, insecure: false},
+		{name: "client certificate", config: Config{ClientCertFile: "client.pem", ClientKeyFile: "client-key.pem"}, insecure: false},
+		{name: "http URL with TLS", config: Config{OTLPEndpoint: "http://collector:4318", TLS: true}, insecure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insecure, _, _ := tt.config.transport()
+			if insecure != tt.insecure {
+				t.Errorf("Expected insecure=%v, got %v", tt.insecure, insecure)
+			}
+		})
+	}
+}
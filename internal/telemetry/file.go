@@ -0,0 +1,126 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient is an OTLP trace client that writes the traces to a file
+// instead of sending them. Every batch is written as one OTLP/JSON export
+// request per line, the format the collector's otlpjsonfile receiver reads.
+type fileClient struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Start creates the output file, overwriting an existing one
+func (c *fileClient) Start(ctx context.Context) error {
+	file, err := os.Create(c.path)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	c.file = file
+	return nil
+}
+
+// Stop closes the output file
+func (c *fileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// UploadTraces appends a batch of spans to the output file
+func (c *fileClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	line, err := MarshalTraces(protoSpans)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return fmt.Errorf("output file %s is closed", c.path)
+	}
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	return nil
+}
+
+// idFields are the OTLP/JSON fields holding trace and span IDs
+var idFields = map[string]bool{
+	"traceId":      true,
+	"spanId":       true,
+	"parentSpanId": true,
+}
+
+// MarshalTraces encodes spans as an OTLP/JSON export request. Unlike the
+// canonical protobuf JSON encoding, OTLP/JSON has trace and span IDs as hex
+// strings and enums as integers.
+func MarshalTraces(protoSpans []*tracepb.ResourceSpans) ([]byte, error) {
+	request := &collectortrace.ExportTraceServiceRequest{ResourceSpans: protoSpans}
+	encoded, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding traces: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("decoding traces: %w", err)
+	}
+	if err := hexIDs(document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+// hexIDs re-encodes the base64 trace and span IDs of a decoded JSON document
+// as hex
+func hexIDs(value any) error {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if id, ok := field.(string); ok && idFields[key] {
+				raw, err := base64.StdEncoding.DecodeString(id)
+				if err != nil {
+					return fmt.Errorf("decoding %s: %w", key, err)
+				}
+				value[key] = hex.EncodeToString(raw)
+				continue
+			}
+			if err := hexIDs(field); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			if err := hexIDs(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
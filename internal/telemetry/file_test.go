@@ -0,0 +1,112 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+)
+
+// otlpJSONSpan is the part of an OTLP/JSON span checked by the tests
+type otlpJSONSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+type otlpJSONRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []otlpJSONSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestNewTracer_OutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	ctx := context.Background()
+	tracer, err := NewTracer(ctx, Config{
+		ServiceName: "test-service",
+		OutputFile:  path,
+		Offline:     true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	logFile, err := os.Open("../../data/log.1")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer logFile.Close()
+	graph, err := buildx.NewParser(logFile).ParseGraph()
+	if err != nil {
+		t.Fatalf("Failed to parse log: %v", err)
+	}
+
+	result, err := tracer.ExportBuildTraces(ctx, graph)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected output file, got %v", err)
+	}
+	defer file.Close()
+
+	var spans []otlpJSONSpan
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var request otlpJSONRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Fatalf("Expected one JSON export request per line, got %v", err)
+		}
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		t.Fatalf("Expected spans in the output file")
+	}
+
+	hexID := regexp.MustCompile(`^[0-9a-f]+$`)
+	spanIDs := make(map[string]bool)
+	for _, span := range spans {
+		spanIDs[span.SpanID] = true
+	}
+	for _, span := range spans {
+		if span.TraceID != result.TraceID {
+			t.Errorf("Expected trace ID %s, got %s", result.TraceID, span.TraceID)
+		}
+		if len(span.SpanID) != 16 || !hexID.MatchString(span.SpanID) {
+			t.Errorf("Expected a hex span ID, got %s", span.SpanID)
+		}
+		if span.ParentSpanID != "" && !spanIDs[span.ParentSpanID] {
+			t.Errorf("Expected parent %s of %s to be in the file", span.ParentSpanID, span.Name)
+		}
+		if span.Kind != 1 {
+			t.Errorf("Expected internal span kind as an integer, got %d", span.Kind)
+		}
+	}
+}
+
+func TestNewTracer_OfflineWithoutOutputFile(t *testing.T) {
+	if _, err := NewTracer(context.Background(), Config{Offline: true}); err == nil {
+		t.Errorf("Expected an error without an output file")
+	}
+}
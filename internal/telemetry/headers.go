@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ParseHeader parses a single "key=value" header. The value is taken as is,
+// so it may contain spaces, commas and further "=" signs. Errors do not
+// quote the header, as it usually carries a secret.
+func ParseHeader(header string) (string, string, error) {
+	key, value, found := strings.Cut(header, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", errors.New("invalid header, expected key=value")
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// ParseHeaders parses a comma separated list of "key=value" headers with
+// URL encoded values, the format of OTEL_EXPORTER_OTLP_HEADERS
+func ParseHeaders(headers string) (map[string]string, error) {
+	return parseKeyValues(headers)
+}
+
+// parseKeyValues parses a comma separated list of "key=value" pairs with URL
+// encoded values, the format of the OpenTelemetry environment variables
+func parseKeyValues(list string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, err := ParseHeader(pair)
+		if err != nil {
+			return nil, err
+		}
+		value, err = url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %q: %w", key, err)
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+// ReadHeadersFile reads headers from a file with one "key=value" header per
+// line. Empty lines and lines starting with "#" are skipped.
+func ReadHeadersFile(path string) (map[string]string, error) {
+	return readKeyValuesFile(path, "headers", ParseHeader)
+}
+
+// readKeyValuesFile reads a file with one "key=value" pair per line, parsed
+// with parse. Empty lines and lines starting with "#" are skipped.
+func readKeyValuesFile(path, kind string, parse func(string) (string, string, error)) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s file: %w", kind, err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s file: %w", kind, err)
+	}
+	return values, nil
+}
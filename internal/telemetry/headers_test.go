@@ -0,0 +1,58 @@
+package telemetry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("Authorization=Bearer%20abc, x-scope=team-a,,")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if headers["Authorization"] != "Bearer abc" {
+		t.Errorf("Expected decoded Authorization header, got '%s'", headers["Authorization"])
+	}
+	if headers["x-scope"] != "team-a" {
+		t.Errorf("Expected x-scope header, got '%s'", headers["x-scope"])
+	}
+	if len(headers) != 2 {
+		t.Errorf("Expected 2 headers, got %d", len(headers))
+	}
+
+	if _, err := ParseHeaders("Bearer secret"); err == nil {
+		t.Errorf("Expected an error for a header without a key")
+	} else if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected the error not to contain the header, got %v", err)
+	}
+}
+
+func TestReadHeadersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers")
+	content := "# collector credentials\n\nAuthorization = Bearer abc=, def\nx-scope=team-a\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write headers file: %v", err)
+	}
+
+	headers, err := ReadHeadersFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if headers["Authorization"] != "Bearer abc=, def" {
+		t.Errorf("Expected Authorization header as is, got '%s'", headers["Authorization"])
+	}
+	if headers["x-scope"] != "team-a" {
+		t.Errorf("Expected x-scope header, got '%s'", headers["x-scope"])
+	}
+
+	if err := os.WriteFile(path, []byte("x-scope=team-a\ninvalid\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write headers file: %v", err)
+	}
+	if _, err := ReadHeadersFile(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceIDFromSeed returns the trace ID of a build exported with the given
+// IDSeed: the first 16 bytes of the SHA-256 hash of the seed. Other systems
+// can compute it ahead of time to link to the trace.
+func TraceIDFromSeed(seed string) trace.TraceID {
+	sum := sha256.Sum256([]byte(seed))
+	var id trace.TraceID
+	copy(id[:], sum[:])
+	return id
+}
+
+// seededIDGenerator derives the trace and span IDs from a seed, so that
+// exporting the same build twice creates the same trace. Span IDs are
+// numbered in the order the spans are started, which only depends on the
+// build log.
+type seededIDGenerator struct {
+	seed string
+
+	mu    sync.Mutex
+	spans uint64
+}
+
+func newSeededIDGenerator(seed string) *seededIDGenerator {
+	return &seededIDGenerator{seed: seed}
+}
+
+// NewIDs returns the trace ID of the seed and the ID of the next span
+func (g *seededIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID := TraceIDFromSeed(g.seed)
+	return traceID, g.NewSpanID(ctx, traceID)
+}
+
+// NewSpanID returns the ID of the next span
+func (g *seededIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		g.spans++
+
+		hash := sha256.New()
+		hash.Write(traceID[:])
+		hash.Write([]byte(g.seed))
+		binary.Write(hash, binary.BigEndian, g.spans) //nolint:errcheck
+
+		var id trace.SpanID
+		copy(id[:], hash.Sum(nil))
+		// An all zero span ID is invalid
+		if id.IsValid() {
+			return id
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+)
+
+func TestTraceIDFromSeed(t *testing.T) {
+	// printf %s build-ref | sha256sum | cut -c1-32
+	expected := "9d18a5e79988f873433cb910556d8347"
+	if id := TraceIDFromSeed("build-ref").String(); id != expected {
+		t.Errorf("Expected trace ID %s, got %s", expected, id)
+	}
+}
+
+// exportSeeded exports data/log.2 with the given seed to a file and returns
+// the trace ID and the content of the file
+func exportSeeded(t *testing.T, seed string) (string, []byte) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	ctx := context.Background()
+	tracer, err := NewTracer(ctx, Config{
+		ServiceName: "test-service",
+		OutputFile:  path,
+		Offline:     true,
+		IDSeed:      seed,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	logFile, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer logFile.Close()
+	graph, err := buildx.NewParser(logFile).ParseGraph()
+	if err != nil {
+		t.Fatalf("Failed to parse log: %v", err)
+	}
+
+	result, err := tracer.ExportBuildTraces(ctx, graph)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	return result.TraceID, data
+}
+
+func TestNewTracer_IDSeed(t *testing.T) {
+	traceID, first := exportSeeded(t, "build-ref")
+	if traceID != TraceIDFromSeed("build-ref").String() {
+		t.Errorf("Expected the trace ID of the seed, got %s", traceID)
+	}
+
+	// Exporting the same build again creates the very same trace
+	_, second := exportSeeded(t, "build-ref")
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected re-exporting the build to create the same spans")
+	}
+
+	otherID, _ := exportSeeded(t, "other-ref")
+	if otherID == traceID {
+		t.Errorf("Expected another seed to create another trace")
+	}
+}
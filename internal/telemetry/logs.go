@@ -0,0 +1,132 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultLogsURLPath is the path log records are sent to over HTTP
+const DefaultLogsURLPath = "/v1/logs"
+
+// newLoggerProvider creates the provider of the log records of the step
+// output, exporting them over OTLP with the same resource as the traces
+func newLoggerProvider(ctx context.Context, config Config) (*sdklog.LoggerProvider, error) {
+	exporter, err := newLogExporter(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP log exporter: %w", err)
+	}
+
+	res, err := newResource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+	), nil
+}
+
+// exportLogs emits every output chunk of a step as a log record. ctx holds
+// the step span, so that the records carry its trace and span IDs.
+func (t *Tracer) exportLogs(ctx context.Context, step buildx.BuildStep) {
+	if t.logs == nil {
+		return
+	}
+
+	for _, chunk := range step.Logs {
+		body := strings.TrimRight(string(chunk.Data), "\r\n")
+		if body == "" {
+			continue
+		}
+
+		timestamp := chunk.Timestamp
+		if timestamp.IsZero() {
+			timestamp = step.Completed
+		}
+
+		var record otellog.Record
+		record.SetTimestamp(timestamp)
+		record.SetBody(otellog.StringValue(body))
+		record.SetSeverity(chunkSeverity(chunk))
+		record.SetSeverityText(chunkSeverity(chunk).String())
+		record.AddAttributes(
+			otellog.String("log.iostream", chunk.StreamName()),
+			otellog.String("buildx.vertex.name", step.Name),
+			otellog.String("buildx.vertex.digest", step.Digest),
+		)
+		t.logs.Emit(ctx, record)
+	}
+}
+
+// chunkSeverity returns the severity of the records of an output chunk.
+// Build tools write progress and diagnostics alike to stderr, so it is a
+// warning rather than an error.
+func chunkSeverity(chunk buildx.LogChunk) otellog.Severity {
+	if chunk.Stream == buildx.StreamStderr {
+		return otellog.SeverityWarn
+	}
+	return otellog.SeverityInfo
+}
+
+// newLogExporter creates the OTLP log exporter for the configured protocol,
+// connecting the same way as the trace exporter
+func newLogExporter(ctx context.Context, config Config) (sdklog.Exporter, error) {
+	conn, err := config.connection(DefaultLogsURLPath)
+	if err != nil {
+		return nil, err
+	}
+	if config.protocol() == ProtocolHTTPProtobuf {
+		return otlploghttp.New(ctx, logHTTPOptions.build(conn)...)
+	}
+	return otlploggrpc.New(ctx, logGRPCOptions.build(conn)...)
+}
+
+var logGRPCOptions = otlpOptions[otlploggrpc.Option]{
+	endpoint:    otlploggrpc.WithEndpoint,
+	endpointURL: otlploggrpc.WithEndpointURL,
+	insecure:    otlploggrpc.WithInsecure,
+	tls: func(config *tls.Config) otlploggrpc.Option {
+		return otlploggrpc.WithTLSCredentials(credentials.NewTLS(config))
+	},
+	headers: otlploggrpc.WithHeaders,
+}
+
+var logHTTPOptions = otlpOptions[otlploghttp.Option]{
+	endpoint:    otlploghttp.WithEndpoint,
+	endpointURL: otlploghttp.WithEndpointURL,
+	urlPath:     otlploghttp.WithURLPath,
+	insecure:    otlploghttp.WithInsecure,
+	tls:         otlploghttp.WithTLSClientConfig,
+	headers:     otlploghttp.WithHeaders,
+}
+
+// logsEndpoint returns the endpoint log records are sent to
+func (c Config) logsEndpoint() string {
+	return c.signalEndpoint(DefaultLogsURLPath)
+}
+
+// signalEndpoint returns the endpoint a signal other than traces is sent to.
+// Over HTTP, the path of an endpoint URL is the path of the signal.
+func (c Config) signalEndpoint(signalPath string) string {
+	endpoint := c.endpoint()
+	if c.protocol() != ProtocolHTTPProtobuf || !hasScheme(endpoint) {
+		return endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	u.Path = c.urlPath(signalPath)
+	return u.String()
+}
@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracer_ExportLogs(t *testing.T) {
+	var mu sync.Mutex
+	var spans []*tracepb.Span
+	var records []*logspb.LogRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case DefaultURLPath:
+			request := &collectortrace.ExportTraceServiceRequest{}
+			if err := proto.Unmarshal(body, request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, resourceSpans := range request.ResourceSpans {
+				for _, scopeSpans := range resourceSpans.ScopeSpans {
+					spans = append(spans, scopeSpans.Spans...)
+				}
+			}
+		case DefaultLogsURLPath:
+			request := &collectorlogs.ExportLogsServiceRequest{}
+			if err := proto.Unmarshal(body, request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, resourceLogs := range request.ResourceLogs {
+				for _, scopeLogs := range resourceLogs.ScopeLogs {
+					records = append(records, scopeLogs.LogRecords...)
+				}
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	tracer, err := NewTracer(ctx, Config{
+		OTLPEndpoint: server.URL,
+		Protocol:     ProtocolHTTPProtobuf,
+		ServiceName:  "test-service",
+		Logs:         true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	base := time.Unix(1000, 0)
+	graph := buildx.NewGraph([]buildx.BuildStep{{
+		Digest:    "sha256:run",
+		Name:      "[stage-0 1/1] RUN make",
+		Started:   base,
+		Completed: base.Add(time.Second),
+		Logs: []buildx.LogChunk{
+			{Stream: buildx.StreamStdout, Data: []byte("compiling\n"), Timestamp: base.Add(100 * time.Millisecond)},
+			{Stream: buildx.StreamStderr, Data: []byte("deprecated flag\n"), Timestamp: base.Add(200 * time.Millisecond)},
+		},
+	}})
+	if _, err := tracer.ExportBuildTraces(ctx, graph); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected traces and logs to be delivered, got %v", err)
+	}
+
+	var stepSpan *tracepb.Span
+	for _, span := range spans {
+		if span.Name == "RUN make" {
+			stepSpan = span
+		}
+	}
+	if stepSpan == nil {
+		t.Fatalf("Expected a RUN make span")
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 log records, got %d", len(records))
+	}
+	for _, record := range records {
+		if !bytes.Equal(record.TraceId, stepSpan.TraceId) || !bytes.Equal(record.SpanId, stepSpan.SpanId) {
+			t.Errorf("Expected record '%s' to belong to the step span", record.Body.GetStringValue())
+		}
+		attrs := map[string]string{}
+		for _, attr := range record.Attributes {
+			attrs[attr.Key] = attr.Value.GetStringValue()
+		}
+		if attrs["buildx.vertex.name"] != "[stage-0 1/1] RUN make" {
+			t.Errorf("Expected vertex name attribute, got '%s'", attrs["buildx.vertex.name"])
+		}
+	}
+
+	if body := records[0].Body.GetStringValue(); body != "compiling" {
+		t.Errorf("Expected body 'compiling', got '%s'", body)
+	}
+	if records[0].SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_INFO {
+		t.Errorf("Expected stdout to be INFO, got %v", records[0].SeverityNumber)
+	}
+	if records[1].SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN {
+		t.Errorf("Expected stderr to be WARN, got %v", records[1].SeverityNumber)
+	}
+	if records[1].TimeUnixNano != uint64(base.Add(200*time.Millisecond).UnixNano()) {
+		t.Errorf("Expected the chunk timestamp, got %d", records[1].TimeUnixNano)
+	}
+}
+
+func TestConfig_LogsEndpoint(t *testing.T) {
+	tests := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{OTLPEndpoint: "collector:4317"}, "collector:4317"},
+		{Config{OTLPEndpoint: "https://otlp.example.com/prefix/v1/traces", Protocol: ProtocolHTTPProtobuf}, "https://otlp.example.com/prefix/v1/logs"},
+		{Config{OTLPEndpoint: "http://collector:4318", Protocol: ProtocolHTTPProtobuf}, "http://collector:4318/v1/logs"},
+	}
+
+	for _, tt := range tests {
+		if endpoint := tt.config.logsEndpoint(); endpoint != tt.expected {
+			t.Errorf("Expected logs endpoint '%s', got '%s'", tt.expected, endpoint)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultMetricsURLPath is the path metrics are sent to over HTTP
+const DefaultMetricsURLPath = "/v1/metrics"
+
+// durationBuckets are the histogram bucket boundaries of build and step
+// durations in seconds, from sub-second cached steps to hour long builds
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// Metrics records build metrics and exports them over OTLP, with the same
+// resource as the traces
+type Metrics struct {
+	provider *sdkmetric.MeterProvider
+	config   Config
+	logger   logger.Logger
+
+	buildDuration metric.Float64Histogram
+	stepDuration  metric.Float64Histogram
+	cacheHits     metric.Int64Counter
+	cacheMisses   metric.Int64Counter
+	bytes         metric.Int64Counter
+	warnings      metric.Int64Counter
+}
+
+// NewMetrics creates a new metrics exporter with the given config
+func NewMetrics(ctx context.Context, config Config) (*Metrics, error) {
+	noop, _ := logger.New(logger.DefaultConfig())
+	return NewMetricsWithLogger(ctx, config, noop)
+}
+
+// NewMetricsWithLogger creates a new metrics exporter with a logger
+func NewMetricsWithLogger(ctx context.Context, config Config, log logger.Logger) (*Metrics, error) {
+	log.Info("Initializing OpenTelemetry metrics",
+		zap.String("endpoint", config.metricsEndpoint()),
+		zap.String("protocol", config.protocol()))
+
+	exporter, err := newMetricExporter(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP metric exporter: %w", err)
+	}
+
+	res, err := newResource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	return newMetrics(provider, config, log)
+}
+
+// newMetrics creates the instruments of the build metrics
+func newMetrics(provider *sdkmetric.MeterProvider, config Config, log logger.Logger) (*Metrics, error) {
+	meter := provider.Meter("buildx")
+	m := &Metrics{provider: provider, config: config, logger: log}
+
+	var err error
+	if m.buildDuration, err = meter.Float64Histogram("buildx.build.duration",
+		metric.WithDescription("Duration of the build, from the earliest step start to the latest step completion"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		return nil, err
+	}
+	if m.stepDuration, err = meter.Float64Histogram("buildx.step.duration",
+		metric.WithDescription("Duration of the build steps"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		return nil, err
+	}
+	if m.cacheHits, err = meter.Int64Counter("buildx.cache.hits",
+		metric.WithDescription("Number of steps that were cached"),
+		metric.WithUnit("{step}")); err != nil {
+		return nil, err
+	}
+	if m.cacheMisses, err = meter.Int64Counter("buildx.cache.misses",
+		metric.WithDescription("Number of steps that were executed"),
+		metric.WithUnit("{step}")); err != nil {
+		return nil, err
+	}
+	if m.bytes, err = meter.Int64Counter("buildx.bytes.transferred",
+		metric.WithDescription("Bytes transferred by the build steps, such as layer downloads"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if m.warnings, err = meter.Int64Counter("buildx.build.warnings",
+		metric.WithDescription("Number of build warnings"),
+		metric.WithUnit("{warning}")); err != nil {
+		return nil, err
+	}
+
+	log.Info("OpenTelemetry metrics initialized")
+	return m, nil
+}
+
+// RecordStep records the metrics of a completed build step
+func (m *Metrics) RecordStep(ctx context.Context, step buildx.BuildStep) {
+	name := buildx.ParseVertexName(step.Name)
+	instruction := name.Command()
+	if name.Internal() {
+		instruction = buildx.InternalStage
+	}
+
+	attrs := metric.WithAttributes(
+		attribute.String("buildx.step.instruction", instruction),
+		attribute.String("buildx.stage.name", name.Group()),
+		attribute.Bool("buildx.step.cached", step.Cached),
+	)
+	m.stepDuration.Record(ctx, step.Completed.Sub(step.Started).Seconds(), attrs)
+
+	// Internal work such as loading the build definition is never cached,
+	// so it is left out of the cache hits and misses
+	if !name.Internal() {
+		stepAttrs := metric.WithAttributes(
+			attribute.String("buildx.step.instruction", instruction),
+			attribute.String("buildx.stage.name", name.Group()),
+		)
+		if step.Cached {
+			m.cacheHits.Add(ctx, 1, stepAttrs)
+		} else {
+			m.cacheMisses.Add(ctx, 1, stepAttrs)
+		}
+	}
+
+	// Only transfers count bytes moved, extractions and other operations
+	// report progress of their own
+	for _, status := range step.Statuses {
+		if status.Transfer() && status.Current > 0 {
+			m.bytes.Add(ctx, status.Current, metric.WithAttributes(
+				attribute.String("buildx.status.operation", status.Operation())))
+		}
+	}
+}
+
+// RecordWarning records a build warning
+func (m *Metrics) RecordWarning(ctx context.Context, warning buildx.Warning) {
+	m.warnings.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("buildx.warning.rule", warning.Rule())))
+}
+
+// RecordBuild records the metrics of the whole build once all of its steps
+// were recorded
+func (m *Metrics) RecordBuild(ctx context.Context, stats buildx.Stats) {
+	if stats.Steps == 0 {
+		return
+	}
+	m.buildDuration.Record(ctx, stats.End.Sub(stats.Start).Seconds(), metric.WithAttributes(
+		attribute.String("buildx.build.outcome", stats.Outcome())))
+}
+
+// RecordGraph records the metrics of all steps and warnings of a build
+func (m *Metrics) RecordGraph(ctx context.Context, graph *buildx.Graph) {
+	for _, step := range graph.Steps() {
+		m.RecordStep(ctx, step)
+	}
+	for _, warning := range graph.Warnings() {
+		m.RecordWarning(ctx, warning)
+	}
+	m.RecordBuild(ctx, graph.Stats())
+	m.logger.Info("Recorded build metrics", zap.Int("steps", graph.Len()))
+}
+
+// Shutdown exports the recorded metrics and shuts down the meter provider
+func (m *Metrics) Shutdown(ctx context.Context) error {
+	m.logger.Info("Shutting down OpenTelemetry metrics")
+	return m.provider.Shutdown(ctx)
+}
+
+// newMetricExporter creates the OTLP metric exporter for the configured
+// protocol, connecting the same way as the trace exporter
+func newMetricExporter(ctx context.Context, config Config) (sdkmetric.Exporter, error) {
+	conn, err := config.connection(DefaultMetricsURLPath)
+	if err != nil {
+		return nil, err
+	}
+	if config.protocol() == ProtocolHTTPProtobuf {
+		return otlpmetrichttp.New(ctx, metricHTTPOptions.build(conn)...)
+	}
+	return otlpmetricgrpc.New(ctx, metricGRPCOptions.build(conn)...)
+}
+
+var metricGRPCOptions = otlpOptions[otlpmetricgrpc.Option]{
+	endpoint:    otlpmetricgrpc.WithEndpoint,
+	endpointURL: otlpmetricgrpc.WithEndpointURL,
+	insecure:    otlpmetricgrpc.WithInsecure,
+	tls: func(config *tls.Config) otlpmetricgrpc.Option {
+		return otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(config))
+	},
+	headers: otlpmetricgrpc.WithHeaders,
+}
+
+var metricHTTPOptions = otlpOptions[otlpmetrichttp.Option]{
+	endpoint:    otlpmetrichttp.WithEndpoint,
+	endpointURL: otlpmetrichttp.WithEndpointURL,
+	urlPath:     otlpmetrichttp.WithURLPath,
+	insecure:    otlpmetrichttp.WithInsecure,
+	tls:         otlpmetrichttp.WithTLSClientConfig,
+	headers:     otlpmetrichttp.WithHeaders,
+}
+
+// metricsEndpoint returns the endpoint metrics are sent to
+func (c Config) metricsEndpoint() string {
+	return c.signalEndpoint(DefaultMetricsURLPath)
+}
@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// sumOf returns the total of a counter
+func sumOf(t *testing.T, rm metricdata.ResourceMetrics, name string) int64 {
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				t.Fatalf("Expected %s to be an int64 sum, got %T", name, m.Data)
+			}
+			var total int64
+			for _, point := range sum.DataPoints {
+				total += point.Value
+			}
+			return total
+		}
+	}
+	return 0
+}
+
+// countOf returns the number of values recorded by a histogram
+func countOf(t *testing.T, rm metricdata.ResourceMetrics, name string) uint64 {
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != name {
+				continue
+			}
+			histogram, ok := m.Data.(metricdata.Histogram[float64])
+			if !ok {
+				t.Fatalf("Expected %s to be a float64 histogram, got %T", name, m.Data)
+			}
+			var count uint64
+			for _, point := range histogram.DataPoints {
+				count += point.Count
+			}
+			return count
+		}
+	}
+	return 0
+}
+
+func TestMetrics_RecordGraph(t *testing.T) {
+	file, err := os.Open("../../data/log.2")
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	graph, err := buildx.NewParser(file).ParseGraph()
+	if err != nil {
+		t.Fatalf("Failed to parse log: %v", err)
+	}
+
+	reader := sdkmetric.NewManualReader()
+	log, _ := logger.New(logger.DefaultConfig())
+	metrics, err := newMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), Config{}, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ctx := context.Background()
+	metrics.RecordGraph(ctx, graph)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var cached, executed, transferred int64
+	for _, step := range graph.Steps() {
+		for _, status := range step.Statuses {
+			if status.Transfer() {
+				transferred += status.Current
+			}
+		}
+		if buildx.ParseVertexName(step.Name).Internal() {
+			continue
+		}
+		if step.Cached {
+			cached++
+		} else {
+			executed++
+		}
+	}
+	if executed == 0 || transferred == 0 {
+		t.Fatalf("Expected the log to have executed steps and transfers")
+	}
+
+	if hits := sumOf(t, rm, "buildx.cache.hits"); hits != cached {
+		t.Errorf("Expected %d cache hits, got %d", cached, hits)
+	}
+	if misses := sumOf(t, rm, "buildx.cache.misses"); misses != executed {
+		t.Errorf("Expected %d cache misses without the internal steps, got %d", executed, misses)
+	}
+	if count := countOf(t, rm, "buildx.step.duration"); count != uint64(graph.Len()) {
+		t.Errorf("Expected %d step durations, got %d", graph.Len(), count)
+	}
+	if count := countOf(t, rm, "buildx.build.duration"); count != 1 {
+		t.Errorf("Expected 1 build duration, got %d", count)
+	}
+	if warnings := sumOf(t, rm, "buildx.build.warnings"); warnings != int64(len(graph.Warnings())) {
+		t.Errorf("Expected %d warnings, got %d", len(graph.Warnings()), warnings)
+	}
+	if bytes := sumOf(t, rm, "buildx.bytes.transferred"); bytes != transferred {
+		t.Errorf("Expected %d bytes transferred, got %d", transferred, bytes)
+	}
+}
+
+func TestNewMetrics_HTTPProtobuf(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	metrics, err := NewMetrics(ctx, Config{
+		OTLPEndpoint: strings.TrimPrefix(server.URL, "http://"),
+		Protocol:     ProtocolHTTPProtobuf,
+		URLPath:      "/otlp/v1/traces",
+		ServiceName:  "test-service",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	metrics.RecordGraph(ctx, oneStepGraph())
+	if err := metrics.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected metrics to be delivered, got %v", err)
+	}
+
+	if len(paths) == 0 || paths[0] != "/otlp/v1/metrics" {
+		t.Errorf("Expected metrics to be sent to /otlp/v1/metrics, got %v", paths)
+	}
+}
+
+func TestConfig_MetricsEndpoint(t *testing.T) {
+	tests := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{OTLPEndpoint: "collector:4317"}, "collector:4317"},
+		{Config{OTLPEndpoint: "https://otlp.example.com/prefix/v1/traces", Protocol: ProtocolHTTPProtobuf}, "https://otlp.example.com/prefix/v1/metrics"},
+		{Config{OTLPEndpoint: "http://collector:4318", Protocol: ProtocolHTTPProtobuf}, "http://collector:4318/v1/metrics"},
+	}
+
+	for _, tt := range tests {
+		if endpoint := tt.config.metricsEndpoint(); endpoint != tt.expected {
+			t.Errorf("Expected metrics endpoint '%s', got '%s'", tt.expected, endpoint)
+		}
+	}
+}
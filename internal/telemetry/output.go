@@ -0,0 +1,78 @@
+package telemetry
+
+import (
+	"unicode/utf8"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Default limits for the output attached to a step span
+const (
+	DefaultMaxLogLines = 100
+	DefaultMaxLogBytes = 16 * 1024
+)
+
+// exportOutput adds the output of a step to its span as "log" events.
+// Only the last lines within the configured limits are kept, since the end
+// of the output usually explains a failure. The last line is always kept,
+// cut to its last MaxLogBytes bytes if it is longer.
+func (t *Tracer) exportOutput(span trace.Span, step buildx.BuildStep) {
+	if len(step.Logs) == 0 || t.config.MaxLogLines <= 0 || t.config.MaxLogBytes <= 0 {
+		return
+	}
+
+	lines := buildx.SplitLines(step.Logs)
+	if len(lines) == 0 {
+		return
+	}
+
+	last := lines[len(lines)-1]
+	truncated := len(last.Text) > t.config.MaxLogBytes
+	if truncated {
+		last.Text = lastBytes(last.Text, t.config.MaxLogBytes)
+	}
+
+	first := len(lines) - 1
+	size := len(last.Text)
+	for first > 0 && len(lines)-first < t.config.MaxLogLines {
+		size += len(lines[first-1].Text)
+		if size > t.config.MaxLogBytes {
+			break
+		}
+		first--
+	}
+
+	kept := append(lines[first:len(lines)-1:len(lines)-1], last)
+	for _, line := range kept {
+		timestamp := line.Timestamp
+		if timestamp.IsZero() {
+			timestamp = step.Completed
+		}
+		span.AddEvent("log",
+			trace.WithTimestamp(timestamp),
+			trace.WithAttributes(
+				attribute.String("log.iostream", line.StreamName()),
+				attribute.String("message", line.Text),
+			))
+	}
+
+	span.SetAttributes(
+		attribute.Int("buildx.log.lines", len(lines)),
+		attribute.Bool("buildx.log.truncated", truncated || first > 0),
+	)
+}
+
+// lastBytes returns the end of text within limit bytes, without splitting a
+// UTF-8 character
+func lastBytes(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	start := len(text) - limit
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return text[start:]
+}
@@ -0,0 +1,91 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Environment variables carrying the W3C context of the parent, as set by CI
+// tracing tools
+const (
+	EnvTraceParent = "TRACEPARENT"
+	EnvTraceState  = "TRACESTATE"
+	EnvBaggage     = "BAGGAGE"
+)
+
+// propagator reads and writes the W3C trace context and baggage
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Parent is the W3C context the build span is a child of
+type Parent struct {
+	TraceParent string
+	TraceState  string
+	Baggage     string
+}
+
+// ApplyEnv fills the fields that are not set yet from the TRACEPARENT,
+// TRACESTATE and BAGGAGE environment variables, looked up with lookup, e.g.
+// os.LookupEnv. The trace state is only taken from the environment along
+// with the trace parent, as it belongs to it.
+func (p *Parent) ApplyEnv(lookup func(string) (string, bool)) {
+	if p.TraceParent == "" {
+		p.TraceParent, _ = lookup(EnvTraceParent)
+		if p.TraceState == "" {
+			p.TraceState, _ = lookup(EnvTraceState)
+		}
+	}
+	if p.Baggage == "" {
+		p.Baggage, _ = lookup(EnvBaggage)
+	}
+}
+
+// Extract returns ctx with the parent span context and baggage. It returns an
+// error along with the context when the trace parent or the baggage is
+// invalid, leaving them out.
+func (p Parent) Extract(ctx context.Context) (context.Context, error) {
+	carrier := propagation.MapCarrier{}
+	if p.TraceParent != "" {
+		carrier.Set("traceparent", p.TraceParent)
+	}
+	if p.TraceState != "" {
+		carrier.Set("tracestate", p.TraceState)
+	}
+	if p.Baggage != "" {
+		carrier.Set("baggage", p.Baggage)
+	}
+	ctx = propagator.Extract(ctx, carrier)
+
+	if p.TraceParent != "" && !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, fmt.Errorf("invalid trace parent %q", p.TraceParent)
+	}
+	if p.Baggage != "" {
+		if _, err := baggage.Parse(p.Baggage); err != nil {
+			return ctx, fmt.Errorf("invalid baggage: %w", err)
+		}
+	}
+	return ctx, nil
+}
+
+// baggageSpanProcessor adds the baggage of the parent context to every span
+// as attributes, so that sampling decisions and correlation keys of the
+// pipeline survive into the build trace
+type baggageSpanProcessor struct{}
+
+// OnStart adds the baggage members in ctx to the span
+func (baggageSpanProcessor) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {
+	for _, member := range baggage.FromContext(ctx).Members() {
+		span.SetAttributes(attribute.String(member.Key(), member.Value()))
+	}
+}
+
+func (baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (baggageSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
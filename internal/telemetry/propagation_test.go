@@ -0,0 +1,95 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParent_ApplyEnv(t *testing.T) {
+	env := envLookup(map[string]string{
+		EnvTraceParent: testTraceParent,
+		EnvTraceState:  "vendor=env",
+		EnvBaggage:     "team=env",
+	})
+
+	parent := Parent{Baggage: "team=flag"}
+	parent.ApplyEnv(env)
+	if parent.TraceParent != testTraceParent || parent.TraceState != "vendor=env" {
+		t.Errorf("Expected the trace context from the environment, got %+v", parent)
+	}
+	if parent.Baggage != "team=flag" {
+		t.Errorf("Expected the baggage flag to take precedence, got '%s'", parent.Baggage)
+	}
+
+	// The trace state belongs to the trace parent it came with
+	parent = Parent{TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	parent.ApplyEnv(env)
+	if parent.TraceState != "" {
+		t.Errorf("Expected no trace state from the environment, got '%s'", parent.TraceState)
+	}
+}
+
+func TestParent_Extract(t *testing.T) {
+	ctx, err := Parent{
+		TraceParent: testTraceParent,
+		TraceState:  "vendor=abc",
+		Baggage:     "team=platform,sampling.priority=1",
+	}.Extract(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if spanCtx.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the parent trace ID, got %s", spanCtx.TraceID())
+	}
+	if state := spanCtx.TraceState().Get("vendor"); state != "abc" {
+		t.Errorf("Expected the trace state, got '%s'", state)
+	}
+	if team := baggage.FromContext(ctx).Member("team").Value(); team != "platform" {
+		t.Errorf("Expected the baggage, got '%s'", team)
+	}
+
+	if _, err := (Parent{TraceParent: "invalid"}).Extract(context.Background()); err == nil {
+		t.Errorf("Expected an invalid trace parent error")
+	}
+	if _, err := (Parent{Baggage: "=invalid"}).Extract(context.Background()); err == nil {
+		t.Errorf("Expected an invalid baggage error")
+	}
+}
+
+func TestBaggageSpanProcessor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(baggageSpanProcessor{}),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+
+	ctx, err := Parent{TraceParent: testTraceParent, Baggage: "team=platform"}.Extract(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx, build := provider.Tracer("test").Start(ctx, "docker-build")
+	_, step := provider.Tracer("test").Start(ctx, "RUN make")
+	step.End()
+	build.End()
+
+	for _, span := range recorder.Ended() {
+		found := false
+		for _, attr := range span.Attributes() {
+			if attr.Key == "team" && attr.Value.AsString() == "platform" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected the baggage on the %s span", span.Name())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExportResult describes an exported build trace
+type ExportResult struct {
+	TraceID string
+
+	// SpanContext is the context of the docker-build span, for later steps
+	// of the pipeline to continue the trace
+	SpanContext trace.SpanContext
+
+	// Start and End are the times of the docker-build span
+	Start time.Time
+	End   time.Time
+}
+
+// TraceParent returns the W3C traceparent header of the docker-build span
+func (r ExportResult) TraceParent() string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), r.SpanContext), carrier)
+	return carrier.Get("traceparent")
+}
+
+// TraceState returns the W3C tracestate header of the docker-build span,
+// which is empty unless the parent had a trace state
+func (r ExportResult) TraceState() string {
+	return r.SpanContext.TraceState().String()
+}
@@ -0,0 +1,30 @@
+package telemetry
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestExportResult_TraceParent(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	state, _ := trace.ParseTraceState("vendor=abc")
+	result := ExportResult{SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: state,
+	})}
+
+	if parent := result.TraceParent(); parent != testTraceParent {
+		t.Errorf("Expected traceparent %s, got %s", testTraceParent, parent)
+	}
+	if state := result.TraceState(); state != "vendor=abc" {
+		t.Errorf("Expected tracestate vendor=abc, got %s", state)
+	}
+
+	if parent := (ExportResult{}).TraceParent(); parent != "" {
+		t.Errorf("Expected no traceparent without a span, got %s", parent)
+	}
+}
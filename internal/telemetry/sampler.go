@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Samplers, named as in OTEL_TRACES_SAMPLER
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// newSampler creates the sampler with the given name. The argument is the
+// sampling ratio of the ratio based samplers, 1 if empty.
+func newSampler(name, arg string) (sdktrace.Sampler, error) {
+	ratio := 1.0
+	if arg != "" {
+		var err error
+		ratio, err = strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid sampler argument %q, expected a ratio between 0 and 1", arg)
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", SamplerParentBasedAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case SamplerAlwaysOn:
+		return sdktrace.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample(), nil
+	case SamplerTraceIDRatio:
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case SamplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case SamplerParentBasedTraceIDRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", name)
+	}
+}
+
+// alwaysSampleKey marks a context whose spans are sampled whatever the
+// sampler decides
+type alwaysSampleKey struct{}
+
+// withAlwaysSample returns ctx marked for its spans to be sampled
+func withAlwaysSample(ctx context.Context) context.Context {
+	return context.WithValue(ctx, alwaysSampleKey{}, true)
+}
+
+// failedBuildSampler samples the spans of contexts marked with
+// withAlwaysSample, such as those of failed builds, and leaves the other
+// spans to the sampler it wraps
+type failedBuildSampler struct {
+	sdktrace.Sampler
+}
+
+// ShouldSample samples marked spans, keeping the trace state of the parent
+func (s failedBuildSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if marked, _ := p.ParentContext.Value(alwaysSampleKey{}).(bool); marked {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.Sampler.ShouldSample(p)
+}
+
+// Description describes the sampler
+func (s failedBuildSampler) Description() string {
+	return fmt.Sprintf("FailedBuildSampler{%s}", s.Sampler.Description())
+}
@@ -0,0 +1,93 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewSampler(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		expected string
+	}{
+		{name: "", expected: "ParentBased{root:AlwaysOnSampler"},
+		{name: SamplerAlwaysOff, expected: "AlwaysOffSampler"},
+		{name: SamplerTraceIDRatio, arg: "0.5", expected: "TraceIDRatioBased{0.5}"},
+		{name: "ParentBased_TraceIDRatio", arg: "0.1", expected: "ParentBased{root:TraceIDRatioBased{0.1}"},
+	}
+
+	for _, tt := range tests {
+		sampler, err := newSampler(tt.name, tt.arg)
+		if err != nil {
+			t.Errorf("Expected no error for '%s', got %v", tt.name, err)
+			continue
+		}
+		if !strings.HasPrefix(sampler.Description(), tt.expected) {
+			t.Errorf("Expected '%s' sampler, got '%s'", tt.expected, sampler.Description())
+		}
+	}
+
+	if _, err := newSampler("sometimes", ""); err == nil {
+		t.Errorf("Expected an error for an unknown sampler")
+	}
+	if _, err := newSampler(SamplerTraceIDRatio, "1.5"); err == nil {
+		t.Errorf("Expected an error for a ratio above 1")
+	}
+}
+
+func TestSampleFailedBuilds(t *testing.T) {
+	base := time.Unix(1000, 0)
+	succeeded := buildx.NewGraph([]buildx.BuildStep{
+		{Digest: "sha256:a", Name: "[1/1] RUN true", Started: base, Completed: base.Add(time.Second)},
+	})
+	failed := buildx.NewGraph([]buildx.BuildStep{
+		{Digest: "sha256:a", Name: "[1/1] RUN false", Started: base, Completed: base.Add(time.Second), Error: "exit code: 1"},
+	})
+
+	tests := []struct {
+		name               string
+		graph              *buildx.Graph
+		sampleFailedBuilds bool
+		expectedSpans      int
+	}{
+		{name: "failed build", graph: failed, sampleFailedBuilds: true, expectedSpans: 3},
+		{name: "successful build", graph: succeeded, sampleFailedBuilds: true, expectedSpans: 0},
+		{name: "failed build without the option", graph: failed, expectedSpans: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			exporter := tracetest.NewInMemoryExporter()
+			log, _ := logger.New(logger.DefaultConfig())
+			tracer, err := NewTracerWithExporter(ctx, exporter, Config{
+				ServiceName:        "test-service",
+				Sampler:            SamplerAlwaysOff,
+				SampleFailedBuilds: tt.sampleFailedBuilds,
+			}, log)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if _, err := tracer.ExportBuildTraces(ctx, tt.graph); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if err := tracer.ForceFlush(ctx); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if spans := len(exporter.GetSpans()); spans != tt.expectedSpans {
+				t.Errorf("Expected %d spans, got %d", tt.expectedSpans, spans)
+			}
+			if err := tracer.Shutdown(ctx); err != nil {
+				t.Errorf("Expected no error on shutdown, got %v", err)
+			}
+		})
+	}
+}
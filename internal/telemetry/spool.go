@@ -0,0 +1,205 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// spoolExt is the extension of the spooled export requests
+const spoolExt = ".pb"
+
+// Retries of FlushSpool. Variables so that tests can shorten them.
+var (
+	flushAttempts       = 5
+	flushInitialBackoff = time.Second
+	flushMaxBackoff     = 30 * time.Second
+)
+
+// deliveryClient wraps an OTLP trace client to keep track of the uploads
+// that failed. The batch span processor only logs export errors, so without
+// it a build whose traces never reached the collector would look exported.
+// When a spool directory is set, the spans of failed uploads are written to
+// it so that they can be delivered later with FlushSpool.
+type deliveryClient struct {
+	otlptrace.Client
+	name     string
+	spoolDir string
+
+	mu      sync.Mutex
+	err     error
+	spooled int
+}
+
+func newDeliveryClient(client otlptrace.Client, name, spoolDir string) *deliveryClient {
+	return &deliveryClient{Client: client, name: name, spoolDir: spoolDir}
+}
+
+// UploadTraces uploads a batch of spans, spooling it if the upload fails
+func (c *deliveryClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	err := c.Client.UploadTraces(ctx, protoSpans)
+	if err == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.spoolDir != "" {
+		spoolErr := spool(c.spoolDir, protoSpans)
+		if spoolErr == nil {
+			c.spooled++
+			return err
+		}
+		err = errors.Join(err, spoolErr)
+	}
+	c.err = errors.Join(c.err, fmt.Errorf("%s: %w", c.name, err))
+	return err
+}
+
+// Err returns the errors of the uploads whose spans were lost
+func (c *deliveryClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Spooled returns the number of batches spooled instead of being uploaded
+func (c *deliveryClient) Spooled() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spooled
+}
+
+// spool writes a batch of spans to the spool directory as a protobuf export
+// request. The file is written under a temporary name and renamed, so that
+// FlushSpool never reads a partial batch.
+func spool(dir string, protoSpans []*tracepb.ResourceSpans) error {
+	data, err := proto.Marshal(&collectortrace.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return fmt.Errorf("encoding spooled spans: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating spool directory: %w", err)
+	}
+
+	spooledAt := time.Now().UnixNano()
+	file, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating spool file: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("writing spool file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("writing spool file: %w", err)
+	}
+
+	// Names start with the time the batch was spooled, so they sort by it
+	name := fmt.Sprintf("%020d-%s%s", spooledAt, strings.TrimPrefix(filepath.Base(file.Name()), ".tmp-"), spoolExt)
+	if err := os.Rename(file.Name(), filepath.Join(dir, name)); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("renaming spool file: %w", err)
+	}
+	return nil
+}
+
+// FlushSpool delivers the batches spooled in dir to the configured endpoint,
+// oldest first, and removes them once delivered. Each batch is retried with
+// exponential backoff. Flushing stops at the first batch that still cannot
+// be delivered, leaving it and the later ones for the next flush. It returns
+// the number of batches delivered.
+func FlushSpool(ctx context.Context, config Config, dir string, log logger.Logger) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolExt))
+	if err != nil {
+		return 0, fmt.Errorf("listing spool directory: %w", err)
+	}
+	sort.Strings(files)
+
+	if len(files) == 0 {
+		log.Info("No spooled traces to flush", zap.String("dir", dir))
+		return 0, nil
+	}
+
+	client, err := newClient(config)
+	if err != nil {
+		return 0, fmt.Errorf("creating OTLP trace client: %w", err)
+	}
+	if err := client.Start(ctx); err != nil {
+		return 0, fmt.Errorf("starting OTLP trace client: %w", err)
+	}
+	defer func() {
+		if err := client.Stop(ctx); err != nil {
+			log.Warn("Error stopping OTLP trace client", zap.Error(err))
+		}
+	}()
+
+	delivered := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return delivered, fmt.Errorf("reading spooled traces: %w", err)
+		}
+		request := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(data, request); err != nil {
+			return delivered, fmt.Errorf("decoding spooled traces %s: %w", file, err)
+		}
+
+		if err := uploadWithBackoff(ctx, client, request.ResourceSpans, log); err != nil {
+			return delivered, fmt.Errorf("delivering spooled traces %s: %w", file, err)
+		}
+		if err := os.Remove(file); err != nil {
+			return delivered, fmt.Errorf("removing delivered traces: %w", err)
+		}
+
+		delivered++
+		log.Info("Delivered spooled traces", zap.String("file", file))
+	}
+
+	return delivered, nil
+}
+
+// uploadWithBackoff uploads a batch of spans, retrying with exponential
+// backoff until it succeeds or the attempts run out
+func uploadWithBackoff(ctx context.Context, client otlptrace.Client, protoSpans []*tracepb.ResourceSpans, log logger.Logger) error {
+	backoff := flushInitialBackoff
+	var err error
+	for attempt := 1; attempt <= flushAttempts; attempt++ {
+		if err = client.UploadTraces(ctx, protoSpans); err == nil {
+			return nil
+		}
+		if attempt == flushAttempts {
+			break
+		}
+
+		log.Warn("Failed to deliver spooled traces, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, flushMaxBackoff)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+)
+
+// newRejectingCollector starts a collector that rejects every export
+// request with an error that is not retried
+func newRejectingCollector(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestTracer_ExportFailure(t *testing.T) {
+	collector := newRejectingCollector(t)
+
+	_, err := exportTo(t, Config{
+		OTLPEndpoint: collector.URL,
+		Protocol:     ProtocolHTTPProtobuf,
+		ServiceName:  "test-service",
+	})
+	if !errors.Is(err, ErrExportFailed) {
+		t.Errorf("Expected ErrExportFailed, got %v", err)
+	}
+}
+
+func TestTracer_SpoolAndFlush(t *testing.T) {
+	previousAttempts, previousBackoff := flushAttempts, flushInitialBackoff
+	flushAttempts, flushInitialBackoff = 2, time.Millisecond
+	t.Cleanup(func() { flushAttempts, flushInitialBackoff = previousAttempts, previousBackoff })
+
+	rejecting := newRejectingCollector(t)
+	dir := filepath.Join(t.TempDir(), "spool")
+
+	tracer, err := exportTo(t, Config{
+		OTLPEndpoint: rejecting.URL,
+		Protocol:     ProtocolHTTPProtobuf,
+		ServiceName:  "test-service",
+		SpoolDir:     dir,
+	})
+	if err != nil {
+		t.Fatalf("Expected spooled spans not to be an error, got %v", err)
+	}
+	if tracer.Spooled() == 0 {
+		t.Fatalf("Expected spans to be spooled")
+	}
+
+	spooled, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt))
+	if len(spooled) != tracer.Spooled() {
+		t.Fatalf("Expected %d spool files, got %d", tracer.Spooled(), len(spooled))
+	}
+
+	log, _ := logger.New(logger.DefaultConfig())
+
+	// The collector is still down, so the spool is kept
+	config := Config{OTLPEndpoint: rejecting.URL, Protocol: ProtocolHTTPProtobuf}
+	if _, err := FlushSpool(context.Background(), config, dir, log); err == nil {
+		t.Errorf("Expected flushing to a rejecting collector to fail")
+	}
+	if remaining, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt)); len(remaining) != len(spooled) {
+		t.Errorf("Expected the spool to be kept, got %d files", len(remaining))
+	}
+
+	collector := newHTTPCollector(t, nil)
+	config.OTLPEndpoint = collector.server.URL
+	delivered, err := FlushSpool(context.Background(), config, dir, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if delivered != len(spooled) {
+		t.Errorf("Expected %d batches delivered, got %d", len(spooled), delivered)
+	}
+	if remaining, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt)); len(remaining) != 0 {
+		t.Errorf("Expected delivered batches to be removed, got %d files", len(remaining))
+	}
+	if len(collector.spanNames()) == 0 {
+		t.Errorf("Expected the spooled spans to reach the collector")
+	}
+}
@@ -0,0 +1,413 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrStreamClosed is returned when an event is sent to a closed BuildStream
+var ErrStreamClosed = errors.New("build stream is closed")
+
+// BuildStream exports build events as spans while the build is running.
+// The build span and the stage spans are started with the first step that
+// starts in them and ended when the stream is closed. Step spans are ended,
+// and thus handed to the exporter, as soon as their step completes.
+type BuildStream struct {
+	tracer *Tracer
+	otel   trace.Tracer
+	ctx    context.Context
+
+	buildCtx context.Context
+	span     trace.Span
+	started  time.Time
+	closed   bool
+
+	stages       map[string]*streamStage
+	spanContexts map[string]trace.SpanContext
+	completedAt  map[string]time.Time
+	pending      []buildx.Warning
+	stats        buildx.Stats
+	warnings     int
+
+	// cached are the steps collapsed into one span, and dropped counts the
+	// steps too short to be exported. The stage spans of the steps left out
+	// stand in for them in the links of the steps depending on them.
+	cached  collapsedSteps
+	dropped int
+	leftOut map[string]trace.SpanContext
+}
+
+// collapsedSteps is what the span of the collapsed cached steps needs of
+// them. Their statuses and output are not retained, so that memory stays
+// bounded while streaming.
+type collapsedSteps struct {
+	names     []string
+	started   time.Time
+	completed time.Time
+}
+
+// add collapses a step
+func (c *collapsedSteps) add(step buildx.BuildStep) {
+	if len(c.names) == 0 || step.Started.Before(c.started) {
+		c.started = step.Started
+	}
+	if len(c.names) == 0 || step.Completed.After(c.completed) {
+		c.completed = step.Completed
+	}
+	c.names = append(c.names, step.Name)
+}
+
+// streamStage is the state of a stage span while the stream is open
+type streamStage struct {
+	name      string
+	ctx       context.Context
+	span      trace.Span
+	started   time.Time
+	completed time.Time
+	steps     int
+	failed    bool
+}
+
+// NewBuildStream creates a stream exporting the build as a child of the
+// span in ctx, if there is one
+func (t *Tracer) NewBuildStream(ctx context.Context) *BuildStream {
+	return &BuildStream{
+		tracer:       t,
+		otel:         t.tracer,
+		ctx:          ctx,
+		stages:       make(map[string]*streamStage),
+		spanContexts: make(map[string]trace.SpanContext),
+		completedAt:  make(map[string]time.Time),
+		leftOut:      make(map[string]trace.SpanContext),
+	}
+}
+
+// Handle exports a build event
+func (s *BuildStream) Handle(event buildx.Event) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	switch event.Type {
+	case buildx.EventStepStarted:
+		s.start(event.Step.Started)
+		s.stage(event.Step)
+	case buildx.EventStepCompleted:
+		s.start(event.Step.Started)
+		s.exportStep(event.Step)
+	case buildx.EventWarning:
+		if s.span == nil {
+			s.pending = append(s.pending, event.Warning)
+			return nil
+		}
+		s.exportWarning(event.Warning)
+	}
+	return nil
+}
+
+// Close ends the stage spans and the build span, and returns the result of
+// the export
+func (s *BuildStream) Close() (ExportResult, error) {
+	if s.closed {
+		return ExportResult{}, ErrStreamClosed
+	}
+
+	// Without any step the build span falls back to the current time
+	s.start(time.Now())
+	for _, warning := range s.pending {
+		s.exportWarning(warning)
+	}
+	s.pending = nil
+	s.closed = true
+
+	stages := make([]*streamStage, 0, len(s.stages))
+	for _, stage := range s.stages {
+		stages = append(stages, stage)
+	}
+	sort.Slice(stages, func(i, j int) bool {
+		return stages[i].started.Before(stages[j].started)
+	})
+
+	for _, stage := range stages {
+		stage.span.SetAttributes(attribute.Int("buildx.stage.steps", stage.steps))
+		if stage.failed {
+			stage.span.SetStatus(codes.Error, "stage failed")
+		}
+		stage.span.End(trace.WithTimestamp(stage.completed))
+	}
+
+	s.exportCached()
+
+	stats := s.stats
+	if s.tracer.config.MinStepDuration > 0 {
+		s.span.SetAttributes(attribute.Int("buildx.build.dropped_steps", s.dropped))
+	}
+	s.span.SetAttributes(
+		attribute.Int("buildx.build.steps", stats.Steps),
+		attribute.Int("buildx.build.cached_steps", stats.CachedSteps),
+		attribute.Float64("buildx.build.cache_hit_ratio", stats.CacheHitRatio()),
+		attribute.Float64("buildx.build.execution_time", stats.ExecutionTime.Seconds()),
+		attribute.Int("buildx.build.warnings", s.warnings),
+	)
+
+	outcome := stats.Outcome()
+	s.span.SetAttributes(attribute.String("buildx.build.outcome", outcome))
+	if outcome != buildx.OutcomeSuccess {
+		s.span.SetStatus(codes.Error, "build "+outcome)
+	}
+
+	// The build span covers the steps from the earliest start to the latest
+	// completion
+	ended := time.Now()
+	if stats.Steps > 0 {
+		ended = stats.End
+	}
+	s.span.End(trace.WithTimestamp(ended))
+
+	result := ExportResult{
+		TraceID:     s.span.SpanContext().TraceID().String(),
+		SpanContext: s.span.SpanContext(),
+		Start:       s.started,
+		End:         ended,
+	}
+	s.tracer.logger.Info("Completed exporting build traces",
+		zap.String("traceID", result.TraceID),
+		zap.Int("steps", stats.Steps))
+
+	return result, nil
+}
+
+// start starts the build span if it has not been started yet
+func (s *BuildStream) start(started time.Time) {
+	if s.span != nil {
+		return
+	}
+
+	t := s.tracer
+	if parentSpanContext := trace.SpanContextFromContext(s.ctx); parentSpanContext.IsValid() {
+		t.logger.Info("Creating build span as child of parent span",
+			zap.String("parentTraceID", parentSpanContext.TraceID().String()))
+	} else {
+		t.logger.Info("Creating new root build span")
+	}
+
+	s.buildCtx, s.span = s.otel.Start(s.ctx, "docker-build", trace.WithTimestamp(started))
+	s.started = started
+
+	// Add version attribute to the span if provided
+	if t.config.Version != "" {
+		s.span.SetAttributes(attribute.String("version", t.config.Version))
+	}
+	if len(t.config.SpanAttributes) > 0 {
+		s.span.SetAttributes(stringAttributes(t.config.SpanAttributes)...)
+	}
+}
+
+// stage returns the stage span of a step, starting it if needed
+func (s *BuildStream) stage(step buildx.BuildStep) *streamStage {
+	name := buildx.ParseVertexName(step.Name).Group()
+	if stage, ok := s.stages[name]; ok {
+		return stage
+	}
+
+	ctx, span := s.otel.Start(s.buildCtx, name,
+		trace.WithTimestamp(step.Started),
+		trace.WithAttributes(attribute.String("buildx.stage.name", name)))
+	if s.tracer.config.Version != "" {
+		span.SetAttributes(attribute.String("version", s.tracer.config.Version))
+	}
+
+	stage := &streamStage{
+		name:      name,
+		ctx:       ctx,
+		span:      span,
+		started:   step.Started,
+		completed: step.Started,
+	}
+	s.stages[name] = stage
+	return stage
+}
+
+// exportStep exports a completed step, unless it is collapsed or dropped.
+// Steps left out of the trace still count towards their stage and the build,
+// and their output is still exported as log records of the stage.
+func (s *BuildStream) exportStep(step buildx.BuildStep) {
+	t := s.tracer
+	stage := s.stage(step)
+	if step.Digest != "" {
+		s.completedAt[step.Digest] = step.Completed
+	}
+
+	switch {
+	case t.config.CollapseCached && step.Cached && step.Error == "":
+		s.cached.add(step)
+		s.leaveOut(stage, step)
+	case s.short(step):
+		s.dropped++
+		s.leaveOut(stage, step)
+	default:
+		s.exportStepSpan(stage, step)
+	}
+
+	stage.steps++
+	stage.failed = stage.failed || step.Error != ""
+	if step.Started.Before(stage.started) {
+		stage.started = step.Started
+	}
+	if step.Completed.After(stage.completed) {
+		stage.completed = step.Completed
+	}
+
+	s.stats.Add(step)
+	if s.stats.Steps%10 == 0 {
+		t.logger.Debug("Exported step traces", zap.Int("count", s.stats.Steps))
+	}
+}
+
+// short reports whether a step succeeded quicker than the minimum duration
+// of the steps exported
+func (s *BuildStream) short(step buildx.BuildStep) bool {
+	minimum := s.tracer.config.MinStepDuration
+	return minimum > 0 && step.Error == "" && !step.Incomplete &&
+		step.Completed.Sub(step.Started) < minimum
+}
+
+// leaveOut exports the output of a step left out of the trace as log records
+// of its stage, and lets the stage span stand in for the step in the links
+// of the steps depending on it
+func (s *BuildStream) leaveOut(stage *streamStage, step buildx.BuildStep) {
+	if step.Digest != "" {
+		s.leftOut[step.Digest] = stage.span.SpanContext()
+	}
+	s.tracer.exportLogs(stage.ctx, step)
+}
+
+// exportStepSpan creates and ends the span of a completed step
+func (s *BuildStream) exportStepSpan(stage *streamStage, step buildx.BuildStep) {
+	t := s.tracer
+	name := buildx.ParseVertexName(step.Name)
+
+	// Name the span after the instruction alone, so that it stays the
+	// same across builds regardless of the step position or caching
+	spanName := name.Instruction
+	if spanName == "" {
+		spanName = step.Name
+	}
+
+	var links []trace.Link
+	for _, input := range step.Inputs {
+		if inputSpanContext, ok := s.spanContexts[input]; ok {
+			links = append(links, trace.Link{
+				SpanContext: inputSpanContext,
+				Attributes:  []attribute.KeyValue{attribute.String("buildx.link.type", "input")},
+			})
+		} else if stageSpanContext, ok := s.leftOut[input]; ok {
+			// The link names the input, as the stage span of an input left
+			// out of the trace may stand in for several of them
+			links = append(links, trace.Link{
+				SpanContext: stageSpanContext,
+				Attributes: []attribute.KeyValue{
+					attribute.String("buildx.link.type", "input"),
+					attribute.String("buildx.vertex.digest", input),
+				},
+			})
+		}
+	}
+
+	// Create child spans for each build step
+	stepCtx, stepSpan := s.otel.Start(stage.ctx, spanName,
+		trace.WithTimestamp(step.Started),
+		trace.WithLinks(links...),
+		trace.WithAttributes(stepAttributes(step, name)...))
+	if step.Digest != "" {
+		s.spanContexts[step.Digest] = stepSpan.SpanContext()
+	}
+
+	// Add version attribute to step spans as well
+	if t.config.Version != "" {
+		stepSpan.SetAttributes(attribute.String("version", t.config.Version))
+	}
+
+	t.exportStatuses(stepCtx, s.otel, step)
+	t.exportOutput(stepSpan, step)
+	t.exportLogs(stepCtx, step)
+
+	if step.Incomplete {
+		stepSpan.SetAttributes(attribute.Bool("buildx.step.incomplete", true))
+	}
+	if step.Error != "" {
+		stepSpan.RecordError(errors.New(step.Error), trace.WithTimestamp(step.Completed))
+		stepSpan.SetStatus(codes.Error, step.Error)
+	} else if step.Incomplete {
+		stepSpan.SetStatus(codes.Error, "step did not complete")
+	}
+
+	stepSpan.End(trace.WithTimestamp(step.Completed))
+}
+
+// exportCached creates one span under the build span for the cached steps
+// collapsed while streaming, from the earliest start to the latest completion
+// of them
+func (s *BuildStream) exportCached() {
+	if len(s.cached.names) == 0 {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.Int("buildx.cached.steps", len(s.cached.names)),
+		attribute.StringSlice("buildx.cached.vertex.names", s.cached.names),
+	}
+	if s.tracer.config.Version != "" {
+		attrs = append(attrs, attribute.String("version", s.tracer.config.Version))
+	}
+
+	_, span := s.otel.Start(s.buildCtx, "cached steps",
+		trace.WithTimestamp(s.cached.started),
+		trace.WithAttributes(attrs...))
+	span.End(trace.WithTimestamp(s.cached.completed))
+}
+
+// exportWarning records a build warning as a "warning" event on the build
+// span, with the Dockerfile location it refers to
+func (s *BuildStream) exportWarning(warning buildx.Warning) {
+	s.warnings++
+
+	attrs := []attribute.KeyValue{
+		attribute.String("message", warning.Message),
+		attribute.Int("buildx.warning.level", warning.Level),
+		attribute.String("buildx.vertex.digest", warning.Vertex),
+	}
+	if rule := warning.Rule(); rule != "" {
+		attrs = append(attrs, attribute.String("buildx.warning.rule", rule))
+	}
+	if len(warning.Detail) > 0 {
+		attrs = append(attrs, attribute.StringSlice("buildx.warning.detail", warning.Detail))
+	}
+	if warning.URL != "" {
+		attrs = append(attrs, attribute.String("buildx.warning.url", warning.URL))
+	}
+	if warning.Filename != "" {
+		attrs = append(attrs, attribute.String("code.filepath", warning.Filename))
+	}
+	if warning.StartLine > 0 {
+		attrs = append(attrs, attribute.Int("code.lineno", warning.StartLine))
+	}
+
+	// Warnings carry no time of their own, so place them at the completion
+	// of their vertex or else at the latest completion seen so far
+	opts := []trace.EventOption{trace.WithAttributes(attrs...)}
+	if completed, ok := s.completedAt[warning.Vertex]; ok {
+		opts = append(opts, trace.WithTimestamp(completed))
+	} else if !s.stats.End.IsZero() {
+		opts = append(opts, trace.WithTimestamp(s.stats.End))
+	}
+	s.span.AddEvent("warning", opts...)
+}
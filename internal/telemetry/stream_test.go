@@ -0,0 +1,215 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newRecordingTracer(t *testing.T) (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	log, _ := logger.New(logger.DefaultConfig())
+	return NewTracerWithProvider(provider, Config{}, log), recorder
+}
+
+func TestBuildStream_SpanTree(t *testing.T) {
+	tracer, recorder := newRecordingTracer(t)
+	base := time.Unix(1000, 0)
+
+	from := buildx.BuildStep{
+		Digest:  "sha256:from",
+		Name:    "[stage-0 1/2] FROM golang",
+		Started: base.Add(time.Second), Completed: base.Add(2 * time.Second),
+	}
+	run := buildx.BuildStep{
+		Digest:  "sha256:run",
+		Name:    "[stage-0 2/2] RUN go build",
+		Inputs:  []string{"sha256:from"},
+		Started: base.Add(2 * time.Second), Completed: base.Add(5 * time.Second),
+	}
+	load := buildx.BuildStep{
+		Digest:  "sha256:load",
+		Name:    "[internal] load build definition from Dockerfile",
+		Started: base, Completed: base.Add(time.Second),
+	}
+
+	result, err := tracer.ExportBuildTraces(context.Background(), buildx.NewGraph([]buildx.BuildStep{from, run, load}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	build, ok := spans["docker-build"]
+	if !ok {
+		t.Fatalf("Expected a docker-build span, got %v", spans)
+	}
+	if build.SpanContext().TraceID().String() != result.TraceID {
+		t.Errorf("Expected returned trace ID to match the build span")
+	}
+	if !result.SpanContext.Equal(build.SpanContext()) {
+		t.Errorf("Expected returned span context to be the build span")
+	}
+	if !result.Start.Equal(build.StartTime()) || !result.End.Equal(build.EndTime()) {
+		t.Errorf("Expected returned times to match the build span, got %v - %v", result.Start, result.End)
+	}
+	if !build.StartTime().Equal(base) || !build.EndTime().Equal(base.Add(5*time.Second)) {
+		t.Errorf("Expected build span to cover the steps, got %v - %v", build.StartTime(), build.EndTime())
+	}
+
+	parents := map[string]string{
+		"stage-0":                               "docker-build",
+		"internal":                              "docker-build",
+		"FROM golang":                           "stage-0",
+		"RUN go build":                          "stage-0",
+		"load build definition from Dockerfile": "internal",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a span named %q", name)
+			continue
+		}
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("Expected %q to be a child of %q", name, parent)
+		}
+	}
+
+	stage := spans["stage-0"]
+	if !stage.StartTime().Equal(from.Started) || !stage.EndTime().Equal(run.Completed) {
+		t.Errorf("Expected stage span to cover its steps, got %v - %v", stage.StartTime(), stage.EndTime())
+	}
+
+	links := spans["RUN go build"].Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID() != spans["FROM golang"].SpanContext().SpanID() {
+		t.Errorf("Expected RUN step to link to the FROM step, got %v", links)
+	}
+}
+
+func TestBuildStream_ExportsStepsAsTheyComplete(t *testing.T) {
+	tracer, recorder := newRecordingTracer(t)
+	base := time.Unix(1000, 0)
+
+	stream := tracer.NewBuildStream(context.Background())
+	step := buildx.BuildStep{Digest: "sha256:a", Name: "[stage-0 1/1] RUN true", Started: base}
+
+	if err := stream.Handle(buildx.Event{Type: buildx.EventStepStarted, Step: step}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(recorder.Ended()) != 0 {
+		t.Errorf("Expected no span to end before the step completes")
+	}
+
+	step.Completed = base.Add(time.Second)
+	if err := stream.Handle(buildx.Event{Type: buildx.EventStepCompleted, Step: step}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ended := recorder.Ended(); len(ended) != 1 || ended[0].Name() != "RUN true" {
+		t.Errorf("Expected the step span to end as soon as the step completes, got %d spans", len(ended))
+	}
+
+	if _, err := stream.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(recorder.Ended()) != 3 {
+		t.Errorf("Expected step, stage and build spans after closing, got %d", len(recorder.Ended()))
+	}
+
+	if err := stream.Handle(buildx.Event{Type: buildx.EventStepStarted, Step: step}); err != ErrStreamClosed {
+		t.Errorf("Expected ErrStreamClosed, got %v", err)
+	}
+}
+
+func TestBuildStream_SpanVolumeControls(t *testing.T) {
+	tracer, recorder := newRecordingTracer(t)
+	tracer.config.MinStepDuration = 100 * time.Millisecond
+	tracer.config.CollapseCached = true
+	base := time.Unix(1000, 0)
+
+	graph := buildx.NewGraph([]buildx.BuildStep{
+		{Digest: "sha256:from", Name: "[stage-0 1/4] FROM docker.io/library/alpine", Started: base, Completed: base, Cached: true},
+		{Digest: "sha256:workdir", Name: "[stage-0 2/4] WORKDIR /app", Started: base.Add(time.Second), Completed: base.Add(time.Second), Cached: true},
+		{Digest: "sha256:copy", Name: "[stage-0 3/4] COPY . .", Started: base.Add(2 * time.Second), Completed: base.Add(2*time.Second + 10*time.Millisecond)},
+		{Digest: "sha256:run", Name: "[stage-0 4/4] RUN make", Inputs: []string{"sha256:copy"}, Started: base.Add(3 * time.Second), Completed: base.Add(5 * time.Second)},
+		{Digest: "sha256:test", Name: "[stage-1 1/1] RUN false", Inputs: []string{"sha256:workdir"}, Started: base.Add(5 * time.Second), Completed: base.Add(5*time.Second + time.Millisecond), Error: "exit code: 1"},
+	})
+	if _, err := tracer.ExportBuildTraces(context.Background(), graph); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"FROM docker.io/library/alpine", "WORKDIR /app", "COPY . ."} {
+		if _, ok := spans[name]; ok {
+			t.Errorf("Expected no span for '%s'", name)
+		}
+	}
+	for _, name := range []string{"RUN make", "RUN false", "stage-0", "stage-1", "docker-build"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Expected a span for '%s'", name)
+		}
+	}
+
+	cached, ok := spans["cached steps"]
+	if !ok {
+		t.Fatalf("Expected a span for the cached steps")
+	}
+	if cached.Parent().SpanID() != spans["docker-build"].SpanContext().SpanID() {
+		t.Errorf("Expected the cached steps span under the build span")
+	}
+	if !cached.StartTime().Equal(base) || !cached.EndTime().Equal(base.Add(time.Second)) {
+		t.Errorf("Expected the cached steps span to cover the cached steps, got %v to %v", cached.StartTime(), cached.EndTime())
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range cached.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if count := attrs["buildx.cached.steps"].AsInt64(); count != 2 {
+		t.Errorf("Expected 2 cached steps, got %d", count)
+	}
+	if names := attrs["buildx.cached.vertex.names"].AsStringSlice(); len(names) != 2 || names[1] != "[stage-0 2/4] WORKDIR /app" {
+		t.Errorf("Expected the names of the cached steps, got %v", names)
+	}
+
+	// The stage span stands in for the inputs left out of the trace
+	for name, input := range map[string]string{"RUN make": "sha256:copy", "RUN false": "sha256:workdir"} {
+		links := spans[name].Links()
+		if len(links) != 1 || links[0].SpanContext.SpanID() != spans["stage-0"].SpanContext().SpanID() {
+			t.Errorf("Expected '%s' to link to the stage-0 span, got %v", name, links)
+			continue
+		}
+		var digest string
+		for _, attr := range links[0].Attributes {
+			if attr.Key == "buildx.vertex.digest" {
+				digest = attr.Value.AsString()
+			}
+		}
+		if digest != input {
+			t.Errorf("Expected the link of '%s' to name input %s, got '%s'", name, input, digest)
+		}
+	}
+
+	build := make(map[attribute.Key]attribute.Value)
+	for _, attr := range spans["docker-build"].Attributes() {
+		build[attr.Key] = attr.Value
+	}
+	if dropped := build["buildx.build.dropped_steps"].AsInt64(); dropped != 1 {
+		t.Errorf("Expected 1 dropped step, got %d", dropped)
+	}
+	if steps := build["buildx.build.steps"].AsInt64(); steps != 5 {
+		t.Errorf("Expected the left out steps to count towards the build, got %d steps", steps)
+	}
+}
@@ -2,13 +2,17 @@ package telemetry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
 	"github.com/sakajunquality/buildx-telemetry/internal/logger"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
@@ -21,13 +25,101 @@ type Config struct {
 	OTLPEndpoint string
 	ServiceName  string
 	Version      string
+
+	// Protocol is the OTLP protocol, ProtocolGRPC by default.
+	// URLPath is the path traces are sent to over HTTP, DefaultURLPath by
+	// default. It is ignored when the endpoint is a URL with a path.
+	Protocol string
+	URLPath  string
+
+	// TLS sends the traces over TLS rather than in plain text, which TLS
+	// certificates imply. It is ignored when the endpoint is a URL, whose
+	// scheme decides instead. CACertFile verifies the collector, and
+	// ClientCertFile and ClientKeyFile authenticate the exporter to it with
+	// mTLS.
+	TLS            bool
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Headers are sent with every export request, e.g. "Authorization"
+	Headers map[string]string
+
+	// ResourceAttributes are added to the resource of the traces. The
+	// service name and version take precedence over them.
+	ResourceAttributes map[string]string
+
+	// SpanAttributes are added to the build span, such as the image name or
+	// the git ref of the build
+	SpanAttributes map[string]string
+
+	// ResourceDetectors describe the environment of the build, such as the
+	// CI job, on the resource. ResourceAttributes take precedence over them.
+	ResourceDetectors []resource.Detector
+
+	// Sampler is one of the OTEL_TRACES_SAMPLER names, parent based always
+	// on by default. SamplerArg is the ratio of the ratio based samplers.
+	Sampler    string
+	SamplerArg string
+
+	// SampleFailedBuilds samples builds that failed or did not complete
+	// whatever the sampler decides. It only applies to builds exported at
+	// once, as the outcome is not known yet when a stream starts.
+	SampleFailedBuilds bool
+
+	// MinStepDuration drops the spans of successful steps shorter than it,
+	// along with their status spans. CollapseCached replaces the spans of
+	// cached steps with one span for all of them.
+	MinStepDuration time.Duration
+	CollapseCached  bool
+
+	// BatchMaxQueueSize, BatchMaxExportBatchSize and BatchExportTimeout tune
+	// the batch span processor, whose defaults or OTEL_BSP_* environment
+	// variables apply when they are zero. BatchBlocking holds up ending
+	// spans while the queue is full instead of dropping them.
+	BatchMaxQueueSize       int
+	BatchMaxExportBatchSize int
+	BatchExportTimeout      time.Duration
+	BatchBlocking           bool
+
+	// IDSeed derives the trace and span IDs from the seed instead of
+	// generating random ones, so that exporting the same build again
+	// creates the same trace. See TraceIDFromSeed.
+	IDSeed string
+
+	// OutputFile is a file the traces are written to as OTLP/JSON, in
+	// addition to being sent to the endpoint unless Offline is set
+	OutputFile string
+	Offline    bool
+
+	// SpoolDir is a directory the spans that could not be sent are written
+	// to, to be delivered later with FlushSpool
+	SpoolDir string
+
+	// MaxLogLines and MaxLogBytes limit the step output attached to each
+	// step span. Output is not attached when either of them is zero.
+	MaxLogLines int
+	MaxLogBytes int
+
+	// Logs exports the whole output of the steps as OTLP log records, in
+	// addition to the traces. It is ignored in offline mode.
+	Logs bool
 }
 
+// ErrExportFailed is returned when spans could not be delivered
+var ErrExportFailed = errors.New("failed to export traces")
+
 // Tracer manages the OpenTelemetry tracing
 type Tracer struct {
-	provider *sdktrace.TracerProvider
-	config   Config
-	logger   logger.Logger
+	// provider is the provider created by the tracer, nil when the spans
+	// are created with a provider of the caller
+	provider    *sdktrace.TracerProvider
+	tracer      trace.Tracer
+	logProvider *sdklog.LoggerProvider
+	logs        otellog.Logger
+	clients     []*deliveryClient
+	config      Config
+	logger      logger.Logger
 }
 
 // NewTracer creates a new telemetry tracer with the given config
@@ -40,9 +132,11 @@ func NewTracer(ctx context.Context, config Config) (*Tracer, error) {
 // NewTracerWithLogger creates a new telemetry tracer with a logger
 func NewTracerWithLogger(ctx context.Context, config Config, log logger.Logger) (*Tracer, error) {
 	log.Info("Initializing OpenTelemetry tracer",
-		zap.String("endpoint", config.OTLPEndpoint),
+		zap.String("endpoint", config.endpoint()),
+		zap.String("protocol", config.protocol()),
 		zap.String("service", config.ServiceName),
-		zap.String("version", config.Version))
+		zap.String("version", config.Version),
+		zap.String("sampler", config.Sampler))
 
 	// Check if we have a parent span context
 	parentSpanContext := trace.SpanContextFromContext(ctx)
@@ -52,18 +146,173 @@ func NewTracerWithLogger(ctx context.Context, config Config, log logger.Logger)
 			zap.String("spanID", parentSpanContext.SpanID().String()))
 	}
 
-	exporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(config.OTLPEndpoint),
-		otlptracegrpc.WithInsecure(),
-	)
+	var clients []*deliveryClient
+	if !config.Offline {
+		client, err := newClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP trace client: %w", err)
+		}
+		clients = append(clients, newDeliveryClient(client, config.endpoint(), config.SpoolDir))
+	}
+	if config.OutputFile != "" {
+		clients = append(clients, newDeliveryClient(&fileClient{path: config.OutputFile}, config.OutputFile, ""))
+		log.Info("Writing traces to file", zap.String("file", config.OutputFile))
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("offline mode requires an output file")
+	}
+
+	exporters, err := newExporters(ctx, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newSDKTracer(ctx, exporters, config, log)
+	if err != nil {
+		shutdownExporters(ctx, exporters)
+		return nil, err
+	}
+	t.clients = clients
+
+	if config.Logs && !config.Offline {
+		logProvider, err := newLoggerProvider(ctx, config)
+		if err != nil {
+			t.provider.Shutdown(ctx) //nolint:errcheck
+			return nil, err
+		}
+		t.logProvider = logProvider
+		t.logs = logProvider.Logger("buildx")
+		log.Info("Exporting step output as log records", zap.String("endpoint", config.logsEndpoint()))
+	}
+
+	log.Info("OpenTelemetry tracer initialized")
+
+	return t, nil
+}
+
+// newExporters creates a trace exporter for every client. If one cannot be
+// created, the exporters created before it are shut down.
+func newExporters(ctx context.Context, clients []*deliveryClient) ([]sdktrace.SpanExporter, error) {
+	var exporters []sdktrace.SpanExporter
+	for _, client := range clients {
+		exporter, err := otlptrace.New(ctx, client)
+		if err != nil {
+			shutdownExporters(ctx, exporters)
+			return nil, fmt.Errorf("creating trace exporter: %w", err)
+		}
+		exporters = append(exporters, exporter)
+	}
+	return exporters, nil
+}
+
+// shutdownExporters shuts down exporters that are not used after all, as
+// the setup failed. Their errors are left out in favor of the setup error.
+func shutdownExporters(ctx context.Context, exporters []sdktrace.SpanExporter) {
+	for _, exporter := range exporters {
+		exporter.Shutdown(ctx) //nolint:errcheck
+	}
+}
+
+// NewTracerWithExporter creates a tracer handing the spans to exporter, such
+// as a tracetest.InMemoryExporter, instead of sending them over OTLP. The
+// settings of the provider in config, such as the resource and the sampler,
+// are applied, and Shutdown flushes the spans to the exporter.
+func NewTracerWithExporter(ctx context.Context, exporter sdktrace.SpanExporter, config Config, log logger.Logger) (*Tracer, error) {
+	t, err := newSDKTracer(ctx, []sdktrace.SpanExporter{exporter}, config, log)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("OpenTelemetry tracer initialized")
+	return t, nil
+}
+
+// NewTracerWithProvider creates a tracer creating its spans with a provider
+// of the caller. The settings of the provider in config are left to the
+// caller, as is shutting the provider down.
+func NewTracerWithProvider(provider trace.TracerProvider, config Config, log logger.Logger) *Tracer {
+	return &Tracer{
+		tracer: provider.Tracer("buildx"),
+		config: config,
+		logger: log,
+	}
+}
+
+// newSDKTracer creates a tracer with its own provider, configured from config
+// and exporting the spans to exporters
+func newSDKTracer(ctx context.Context, exporters []sdktrace.SpanExporter, config Config, log logger.Logger) (*Tracer, error) {
+	sampler, err := newSampler(config.Sampler, config.SamplerArg)
 	if err != nil {
-		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+		return nil, err
+	}
+	if config.SampleFailedBuilds {
+		sampler = failedBuildSampler{sampler}
+	}
+
+	res, err := newResource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithSpanProcessor(baggageSpanProcessor{}),
+	}
+	for _, exporter := range exporters {
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter, config.batchOptions()...))
+	}
+	if config.IDSeed != "" {
+		providerOpts = append(providerOpts, sdktrace.WithIDGenerator(newSeededIDGenerator(config.IDSeed)))
+		// A parent span context keeps its trace ID, only span IDs are derived
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			log.Info("Deriving the trace ID from the seed",
+				zap.String("traceID", TraceIDFromSeed(config.IDSeed).String()))
+		}
 	}
+	provider := sdktrace.NewTracerProvider(providerOpts...)
 
-	// Prepare resource attributes
-	resourceOpts := []resource.Option{
-		resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+	return &Tracer{
+		provider: provider,
+		tracer:   provider.Tracer("buildx"),
+		config:   config,
+		logger:   log,
+	}, nil
+}
+
+// batchOptions returns the options of the batch span processor set in the
+// config
+func (c Config) batchOptions() []sdktrace.BatchSpanProcessorOption {
+	var opts []sdktrace.BatchSpanProcessorOption
+	if c.BatchMaxQueueSize > 0 {
+		opts = append(opts, sdktrace.WithMaxQueueSize(c.BatchMaxQueueSize))
+	}
+	if c.BatchMaxExportBatchSize > 0 {
+		opts = append(opts, sdktrace.WithMaxExportBatchSize(c.BatchMaxExportBatchSize))
+	}
+	if c.BatchExportTimeout > 0 {
+		opts = append(opts, sdktrace.WithExportTimeout(c.BatchExportTimeout))
 	}
+	if c.BatchBlocking {
+		opts = append(opts, sdktrace.WithBlocking())
+	}
+	return opts
+}
+
+// newResource creates the resource describing the build, shared by the
+// traces and the metrics
+func newResource(ctx context.Context, config Config) (*resource.Resource, error) {
+	// Prepare resource attributes, letting the custom attributes win over
+	// the detected ones and the service name win over a service.name among
+	// the custom attributes
+	var resourceOpts []resource.Option
+	if len(config.ResourceDetectors) > 0 {
+		resourceOpts = append(resourceOpts, resource.WithDetectors(config.ResourceDetectors...))
+	}
+	if len(config.ResourceAttributes) > 0 {
+		resourceOpts = append(resourceOpts, resource.WithAttributes(stringAttributes(config.ResourceAttributes)...))
+	}
+	resourceOpts = append(resourceOpts,
+		resource.WithAttributes(semconv.ServiceName(config.ServiceName)))
 
 	// Add version information if provided
 	if config.Version != "" {
@@ -76,82 +325,182 @@ func NewTracerWithLogger(ctx context.Context, config Config, log logger.Logger)
 	if err != nil {
 		return nil, fmt.Errorf("creating resource: %w", err)
 	}
+	return res, nil
+}
 
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
-	)
+// ExportBuildTraces exports the build graph as OpenTelemetry traces.
+// Steps are grouped under a span per Dockerfile stage within the build span,
+// and linked to the spans of the steps they depend on.
+func (t *Tracer) ExportBuildTraces(ctx context.Context, graph *buildx.Graph) (ExportResult, error) {
+	t.logger.Info("Starting to export build traces", zap.Int("steps", graph.Len()))
 
-	otel.SetTracerProvider(tracerProvider)
+	if t.config.SampleFailedBuilds && graph.Outcome() != buildx.OutcomeSuccess {
+		t.logger.Info("Sampling the build as it did not succeed", zap.String("outcome", graph.Outcome()))
+		ctx = withAlwaysSample(ctx)
+	}
 
-	log.Info("OpenTelemetry tracer initialized")
+	stream := t.NewBuildStream(ctx)
 
-	return &Tracer{
-		provider: tracerProvider,
-		config:   config,
-		logger:   log,
-	}, nil
-}
+	// Replay the build through the stream. Starting the steps in the order
+	// they started gives the build and stage spans their earliest start.
+	steps := graph.Steps()
+	started := make([]buildx.BuildStep, len(steps))
+	copy(started, steps)
+	sort.SliceStable(started, func(i, j int) bool {
+		return started[i].Started.Before(started[j].Started)
+	})
+	for _, step := range started {
+		if err := stream.Handle(buildx.Event{Type: buildx.EventStepStarted, Step: step}); err != nil {
+			return ExportResult{}, err
+		}
+	}
 
-// ExportBuildTraces exports the build steps as OpenTelemetry traces
-func (t *Tracer) ExportBuildTraces(ctx context.Context, steps []buildx.BuildStep) (string, error) {
-	t.logger.Info("Starting to export build traces", zap.Int("steps", len(steps)))
+	// Complete the steps in dependency order so the spans of the inputs
+	// already exist when a step links to them
+	for _, step := range steps {
+		if err := stream.Handle(buildx.Event{Type: buildx.EventStepCompleted, Step: step}); err != nil {
+			return ExportResult{}, err
+		}
+	}
 
-	// Create a new span for the build, potentially as a child of an existing trace
-	tracer := otel.Tracer("buildx")
+	for _, warning := range graph.Warnings() {
+		if err := stream.Handle(buildx.Event{Type: buildx.EventWarning, Warning: warning}); err != nil {
+			return ExportResult{}, err
+		}
+	}
 
-	// Check if we have a parent span in the context
-	parentSpanContext := trace.SpanContextFromContext(ctx)
+	return stream.Close()
+}
 
-	var span trace.Span
-	if parentSpanContext.IsValid() {
-		t.logger.Info("Creating build span as child of parent span",
-			zap.String("parentTraceID", parentSpanContext.TraceID().String()))
-		ctx, span = tracer.Start(ctx, "docker-build")
-	} else {
-		t.logger.Info("Creating new root build span")
-		ctx, span = tracer.Start(ctx, "docker-build")
+// stringAttributes converts string key-value pairs to attributes, sorted by key
+func stringAttributes(values map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	// Add version attribute to the span if provided
-	if t.config.Version != "" {
-		span.SetAttributes(attribute.String("version", t.config.Version))
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, attribute.String(key, values[key]))
 	}
+	return attrs
+}
 
-	defer span.End()
+// stepAttributes returns the attributes describing a build step
+func stepAttributes(step buildx.BuildStep, name buildx.VertexName) []attribute.KeyValue {
+	instruction := name.Command()
+	if name.Internal() {
+		instruction = buildx.InternalStage
+	}
 
-	traceID := span.SpanContext().TraceID()
+	attrs := []attribute.KeyValue{
+		attribute.String("buildx.vertex.name", step.Name),
+		attribute.String("buildx.vertex.digest", step.Digest),
+		attribute.Bool("buildx.step.cached", step.Cached),
+		attribute.String("buildx.step.instruction", instruction),
+		attribute.String("buildx.step.args", name.Args()),
+		attribute.String("buildx.stage.name", name.Group()),
+		attribute.Float64("buildx.step.duration", step.Completed.Sub(step.Started).Seconds()),
+	}
+	if !name.Internal() {
+		attrs = append(attrs,
+			attribute.Int("buildx.step.index", name.Index),
+			attribute.Int("buildx.step.total", name.Total))
+	}
+	if name.Platform != "" {
+		attrs = append(attrs, attribute.String("buildx.platform", name.Platform))
+	}
+	if len(step.Inputs) > 0 {
+		attrs = append(attrs, attribute.StringSlice("buildx.vertex.inputs", step.Inputs))
+	}
+	return attrs
+}
 
-	for i, step := range steps {
-		spanName := step.Name
-		if step.Cached {
-			spanName += " (cached)"
+// exportStatuses creates a child span of the step span for every operation
+// that ran within the step, such as layer downloads and extractions. The spans
+// are named after the operation, as the status IDs hold digests and paths.
+func (t *Tracer) exportStatuses(ctx context.Context, tracer trace.Tracer, step buildx.BuildStep) {
+	for _, status := range step.Statuses {
+		started := status.Started
+		if started.IsZero() {
+			started = step.Started
+		}
+		completed := status.Completed
+		if completed.Before(started) {
+			completed = started
 		}
 
-		// Create child spans for each build step
-		_, stepSpan := tracer.Start(ctx, spanName, trace.WithTimestamp(step.Started))
-
-		// Add version attribute to step spans as well
+		attrs := []attribute.KeyValue{
+			attribute.String("buildx.status.id", status.ID),
+			attribute.String("buildx.status.operation", status.Operation()),
+			attribute.Int64("buildx.status.bytes", status.Current),
+		}
+		if status.Total > 0 {
+			attrs = append(attrs, attribute.Int64("buildx.status.bytes_total", status.Total))
+		}
+		if seconds := completed.Sub(started).Seconds(); seconds > 0 && status.Current > 0 {
+			attrs = append(attrs, attribute.Float64("buildx.status.bytes_per_second", float64(status.Current)/seconds))
+		}
 		if t.config.Version != "" {
-			stepSpan.SetAttributes(attribute.String("version", t.config.Version))
+			attrs = append(attrs, attribute.String("version", t.config.Version))
 		}
 
-		stepSpan.End(trace.WithTimestamp(step.Completed))
-
-		if i%10 == 0 && i > 0 {
-			t.logger.Debug("Exported step traces", zap.Int("count", i))
-		}
+		_, statusSpan := tracer.Start(ctx, status.Operation(),
+			trace.WithTimestamp(started),
+			trace.WithAttributes(attrs...))
+		statusSpan.End(trace.WithTimestamp(completed))
 	}
+}
 
-	t.logger.Info("Completed exporting build traces",
-		zap.String("traceID", traceID.String()),
-		zap.Int("steps", len(steps)))
-
-	return traceID.String(), nil
+// ForceFlush exports the spans ended so far without shutting the tracer
+// down, e.g. to inspect them in an in-memory exporter, which forgets its
+// spans on shutdown
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	return t.provider.ForceFlush(ctx)
 }
 
-// Shutdown gracefully shuts down the tracer
+// Shutdown gracefully shuts down the tracer, flushing the remaining spans.
+// It returns ErrExportFailed if any spans could not be delivered, unless
+// they were spooled.
 func (t *Tracer) Shutdown(ctx context.Context) error {
 	t.logger.Info("Shutting down OpenTelemetry tracer")
-	return t.provider.Shutdown(ctx)
+
+	// Everything is shut down even if something fails, so that no error
+	// about the delivery of the traces is lost
+	var errs []error
+	if t.provider != nil {
+		if err := t.provider.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if t.logProvider != nil {
+		if err := t.logProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutting down log records: %w", err))
+		}
+	}
+
+	var clientErrs []error
+	for _, client := range t.clients {
+		if err := client.Err(); err != nil {
+			clientErrs = append(clientErrs, err)
+		}
+	}
+	if len(clientErrs) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrExportFailed, errors.Join(clientErrs...)))
+	}
+	return errors.Join(errs...)
+}
+
+// Spooled returns the number of batches of spans that were spooled because
+// they could not be sent
+func (t *Tracer) Spooled() int {
+	spooled := 0
+	for _, client := range t.clients {
+		spooled += client.Spooled()
+	}
+	return spooled
 }
@@ -2,7 +2,20 @@ package telemetry
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestConfig(t *testing.T) {
@@ -43,3 +56,286 @@ func TestExportTracesWithNoSteps(t *testing.T) {
 		t.Skip("Expected connection error, but none occurred. This may happen if you're running with a real collector.")
 	}
 }
+
+func TestNewTracer_LeavesGlobalProviderAlone(t *testing.T) {
+	global := otel.GetTracerProvider()
+	ctx := context.Background()
+	log, _ := logger.New(logger.DefaultConfig())
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	withProvider := NewTracerWithProvider(provider, Config{}, log)
+	withExporter, err := NewTracerWithExporter(ctx, tracetest.NewInMemoryExporter(), Config{}, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	graph := buildx.NewGraph([]buildx.BuildStep{{Digest: "sha256:a", Name: "RUN true", Started: time.Unix(1000, 0), Completed: time.Unix(1001, 0)}})
+	for _, tracer := range []*Tracer{withProvider, withExporter} {
+		if _, err := tracer.ExportBuildTraces(ctx, graph); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := tracer.Shutdown(ctx); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if otel.GetTracerProvider() != global {
+		t.Errorf("Expected the global tracer provider to be left alone")
+	}
+	if len(recorder.Ended()) == 0 {
+		t.Errorf("Expected the spans to be created with the provider of the caller")
+	}
+	// The provider of the caller is left running
+	ended := len(recorder.Ended())
+	_, span := provider.Tracer("test").Start(ctx, "after")
+	span.End()
+	if len(recorder.Ended()) != ended+1 {
+		t.Errorf("Expected the provider of the caller to keep recording after Shutdown")
+	}
+}
+
+func TestExportOutputLimits(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	tracer := &Tracer{config: Config{MaxLogLines: 2, MaxLogBytes: 1024}}
+
+	step := buildx.BuildStep{
+		Name:      "RUN npm install",
+		Completed: time.Now(),
+		Logs: []buildx.LogChunk{
+			{Stream: buildx.StreamStdout, Data: []byte("one\ntwo\n")},
+			{Stream: buildx.StreamStderr, Data: []byte("three\n")},
+		},
+	}
+
+	_, span := provider.Tracer("test").Start(context.Background(), step.Name)
+	tracer.exportOutput(span, step)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+
+	events := spans[0].Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected the last 2 lines as events, got %d", len(events))
+	}
+
+	for _, attr := range events[1].Attributes {
+		if attr.Key == "message" && attr.Value.AsString() != "three" {
+			t.Errorf("Expected last event to be 'three', got '%s'", attr.Value.AsString())
+		}
+		if attr.Key == "log.iostream" && attr.Value.AsString() != "stderr" {
+			t.Errorf("Expected last event on stderr, got '%s'", attr.Value.AsString())
+		}
+	}
+
+	truncated := false
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "buildx.log.truncated" {
+			truncated = attr.Value.AsBool()
+		}
+	}
+	if !truncated {
+		t.Errorf("Expected span to be marked as truncated")
+	}
+}
+
+func TestExportOutputKeepsLastLine(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	tracer := &Tracer{config: Config{MaxLogLines: 10, MaxLogBytes: 8}}
+
+	// The last line is split over two chunks and longer than the byte limit
+	step := buildx.BuildStep{
+		Name:      "RUN make",
+		Completed: time.Now(),
+		Logs: []buildx.LogChunk{
+			{Stream: buildx.StreamStderr, Data: []byte("short\nerror: some")},
+			{Stream: buildx.StreamStderr, Data: []byte("thing long\n")},
+		},
+	}
+
+	_, span := provider.Tracer("test").Start(context.Background(), step.Name)
+	tracer.exportOutput(span, step)
+	span.End()
+
+	events := recorder.Ended()[0].Events()
+	if len(events) != 1 {
+		t.Fatalf("Expected the last line as the only event, got %d", len(events))
+	}
+	for _, attr := range events[0].Attributes {
+		if attr.Key == "message" && attr.Value.AsString() != "ing long" {
+			t.Errorf("Expected the end of the last line, got '%s'", attr.Value.AsString())
+		}
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range recorder.Ended()[0].Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if !attrs["buildx.log.truncated"].AsBool() {
+		t.Errorf("Expected span to be marked as truncated")
+	}
+	if lines := attrs["buildx.log.lines"].AsInt64(); lines != 2 {
+		t.Errorf("Expected 2 lines, got %d", lines)
+	}
+}
+
+func TestLastBytes(t *testing.T) {
+	if text := lastBytes("héllo", 4); text != "llo" {
+		t.Errorf("Expected 'llo' without a split character, got '%s'", text)
+	}
+	if text := lastBytes("abc", 5); text != "abc" {
+		t.Errorf("Expected 'abc', got '%s'", text)
+	}
+}
+
+func TestStepAttributes(t *testing.T) {
+	step := buildx.BuildStep{
+		Digest:    "sha256:abc",
+		Name:      "[stage-1 3/7] COPY go.mod go.sum ./",
+		Inputs:    []string{"sha256:def", "sha256:ghi"},
+		Started:   time.Unix(100, 0),
+		Completed: time.Unix(102, 0),
+		Cached:    true,
+	}
+
+	attrs := make(map[string]string)
+	for _, attr := range stepAttributes(step, buildx.ParseVertexName(step.Name)) {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+
+	expected := map[string]string{
+		"buildx.vertex.digest":    "sha256:abc",
+		"buildx.step.cached":      "true",
+		"buildx.step.instruction": "COPY",
+		"buildx.step.args":        "go.mod go.sum ./",
+		"buildx.stage.name":       "stage-1",
+		"buildx.step.index":       "3",
+		"buildx.step.total":       "7",
+		"buildx.step.duration":    "2",
+		"buildx.vertex.inputs":    `["sha256:def","sha256:ghi"]`,
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, value, attrs[key])
+		}
+	}
+
+	internal := buildx.BuildStep{Name: "[internal] load .dockerignore"}
+	for _, attr := range stepAttributes(internal, buildx.ParseVertexName(internal.Name)) {
+		if attr.Key == "buildx.step.instruction" && attr.Value.AsString() != "internal" {
+			t.Errorf("Expected internal instruction, got '%s'", attr.Value.AsString())
+		}
+		if attr.Key == "buildx.step.index" {
+			t.Errorf("Expected no step index for internal work")
+		}
+	}
+}
+
+func TestNewResource_Precedence(t *testing.T) {
+	detected := resource.NewSchemaless(
+		attribute.String("cicd.pipeline.name", "detected"),
+		attribute.String("vcs.ref.head.name", "main"),
+	)
+	res, err := newResource(context.Background(), Config{
+		ServiceName: "test-service",
+		ResourceAttributes: map[string]string{
+			"cicd.pipeline.name": "custom",
+			"service.name":       "ignored",
+		},
+		ResourceDetectors: []resource.Detector{staticDetector{detected}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	attrs := map[string]string{}
+	for _, attr := range res.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsString()
+	}
+	expected := map[string]string{
+		"cicd.pipeline.name": "custom",
+		"vcs.ref.head.name":  "main",
+		"service.name":       "test-service",
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("Expected %s to be '%s', got '%s'", key, value, attrs[key])
+		}
+	}
+}
+
+// staticDetector is a resource detector returning a fixed resource
+type staticDetector struct {
+	res *resource.Resource
+}
+
+func (d staticDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	return d.res, nil
+}
+
+func TestNewExporters_ShutsDownOnError(t *testing.T) {
+	dir := t.TempDir()
+	created := &fileClient{path: filepath.Join(dir, "traces.jsonl")}
+	failing := &fileClient{path: filepath.Join(dir, "missing", "traces.jsonl")}
+
+	_, err := newExporters(context.Background(), []*deliveryClient{
+		newDeliveryClient(created, created.path, ""),
+		newDeliveryClient(failing, failing.path, ""),
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for the missing directory")
+	}
+	if created.file != nil {
+		t.Errorf("Expected the exporter created before the error to be shut down")
+	}
+}
+
+// failingShutdownExporter is a span exporter whose shutdown fails
+type failingShutdownExporter struct {
+	tracetest.InMemoryExporter
+}
+
+func (e *failingShutdownExporter) Shutdown(context.Context) error {
+	return errors.New("exporter shutdown failed")
+}
+
+// shutdownLogExporter is a log exporter recording whether it was shut down
+type shutdownLogExporter struct {
+	shutdown bool
+}
+
+func (e *shutdownLogExporter) Export(context.Context, []sdklog.Record) error { return nil }
+func (e *shutdownLogExporter) ForceFlush(context.Context) error              { return nil }
+func (e *shutdownLogExporter) Shutdown(context.Context) error {
+	e.shutdown = true
+	return nil
+}
+
+func TestTracer_ShutdownReportsEveryError(t *testing.T) {
+	logExporter := &shutdownLogExporter{}
+	log, _ := logger.New(logger.DefaultConfig())
+	tracer := &Tracer{
+		provider:    sdktrace.NewTracerProvider(sdktrace.WithSyncer(&failingShutdownExporter{})),
+		logProvider: sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(logExporter))),
+		clients:     []*deliveryClient{{name: "collector", err: errors.New("upload rejected")}},
+		logger:      log,
+	}
+
+	err := tracer.Shutdown(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exporter shutdown failed") {
+		t.Errorf("Expected the error of the trace provider, got %v", err)
+	}
+	if !errors.Is(err, ErrExportFailed) {
+		t.Errorf("Expected ErrExportFailed despite the failed provider shutdown, got %v", err)
+	}
+	if !logExporter.shutdown {
+		t.Errorf("Expected the log provider to be shut down despite the failed provider shutdown")
+	}
+}
@@ -0,0 +1,141 @@
+package telemetry
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sakajunquality/buildx-telemetry/internal/buildx"
+	"github.com/sakajunquality/buildx-telemetry/internal/logger"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var update = flag.Bool("update", false, "update the golden span trees in testdata")
+
+// spanTree renders spans as an indented tree of their names. Siblings are
+// ordered by start time, and by their subtrees when they start together.
+func spanTree(spans tracetest.SpanStubs) string {
+	children := make(map[trace.SpanID][]tracetest.SpanStub)
+	for _, span := range spans {
+		children[span.Parent.SpanID()] = append(children[span.Parent.SpanID()], span)
+	}
+
+	var render func(span tracetest.SpanStub, depth int) string
+	render = func(span tracetest.SpanStub, depth int) string {
+		var b strings.Builder
+		b.WriteString(strings.Repeat("  ", depth) + span.Name + "\n")
+		b.WriteString(renderChildren(children[span.SpanContext.SpanID()], depth+1, render))
+		return b.String()
+	}
+	return renderChildren(children[trace.SpanID{}], 0, render)
+}
+
+// renderChildren renders sibling spans in a stable order
+func renderChildren(spans []tracetest.SpanStub, depth int, render func(tracetest.SpanStub, int) string) string {
+	type rendered struct {
+		span tracetest.SpanStub
+		text string
+	}
+	subtrees := make([]rendered, 0, len(spans))
+	for _, span := range spans {
+		subtrees = append(subtrees, rendered{span: span, text: render(span, depth)})
+	}
+	sort.Slice(subtrees, func(i, j int) bool {
+		if !subtrees[i].span.StartTime.Equal(subtrees[j].span.StartTime) {
+			return subtrees[i].span.StartTime.Before(subtrees[j].span.StartTime)
+		}
+		return subtrees[i].text < subtrees[j].text
+	})
+
+	var b strings.Builder
+	for _, subtree := range subtrees {
+		b.WriteString(subtree.text)
+	}
+	return b.String()
+}
+
+// exportTree exports a log in data/ through an in-memory exporter, at once or
+// streamed while it is parsed, and returns its span tree
+func exportTree(t *testing.T, name string, streamed bool) string {
+	t.Helper()
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	log, _ := logger.New(logger.DefaultConfig())
+	tracer, err := NewTracerWithExporter(ctx, exporter, Config{ServiceName: "test-service"}, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	logFile, err := os.Open(filepath.Join("../../data", name))
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer logFile.Close()
+
+	if streamed {
+		stream := tracer.NewBuildStream(ctx)
+		if err := buildx.NewParser(logFile).Stream(stream.Handle); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := stream.Close(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	} else {
+		graph, err := buildx.NewParser(logFile).ParseGraph()
+		if err != nil {
+			t.Fatalf("Failed to parse log: %v", err)
+		}
+		if _, err := tracer.ExportBuildTraces(ctx, graph); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// The in-memory exporter forgets its spans on shutdown
+	if err := tracer.ForceFlush(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tree := spanTree(exporter.GetSpans())
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	return tree
+}
+
+func TestNewTracerWithExporter_SpanTrees(t *testing.T) {
+	for _, name := range []string{"log.1", "log.2"} {
+		t.Run(name, func(t *testing.T) {
+			tree := exportTree(t, name, false)
+
+			golden := filepath.Join("testdata", name+".tree")
+			if *update {
+				if err := os.WriteFile(golden, []byte(tree), 0o644); err != nil {
+					t.Fatalf("Failed to update %s: %v", golden, err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", golden, err)
+			}
+			if tree != string(expected) {
+				t.Errorf("Expected span tree:\n%s\ngot:\n%s", expected, tree)
+			}
+		})
+	}
+}
+
+func TestBuildStream_SameTreeAsBatch(t *testing.T) {
+	for _, name := range []string{"log.1", "log.2"} {
+		t.Run(name, func(t *testing.T) {
+			batch := exportTree(t, name, false)
+			streamed := exportTree(t, name, true)
+			if streamed != batch {
+				t.Errorf("Expected the streamed span tree to equal the batch one:\n%s\ngot:\n%s", batch, streamed)
+			}
+		})
+	}
+}